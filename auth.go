@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Access scopes. Control scope includes read scope.
+const (
+	scopeRead    = "read"
+	scopeControl = "control"
+)
+
+type authConfig struct {
+	Users  []authUser
+	Tokens []authToken
+}
+
+type authUser struct {
+	Username     string
+	PasswordHash string // bcrypt hash
+	Scope        string
+}
+
+type authToken struct {
+	Token string
+	Scope string
+}
+
+var (
+	// bcrypt is slow on purpose, so the verified passwords are remembered along with their hashes
+	authCacheMu sync.Mutex
+	authCache   = make(map[string]string)
+)
+
+// enabled returns true if any users or tokens are configured, otherwise the API is open
+func (c authConfig) enabled() bool {
+	return len(c.Users) > 0 || len(c.Tokens) > 0
+}
+
+func validateAuthConfig(c authConfig) error {
+	for _, u := range c.Users {
+		if u.Username == "" {
+			return fmt.Errorf("auth: username must not be empty")
+		}
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return fmt.Errorf("auth: user %s: passwordHash must be a bcrypt hash: %v", u.Username, err)
+		}
+		if u.Scope != scopeRead && u.Scope != scopeControl {
+			return fmt.Errorf("auth: user %s: scope must be either read or control", u.Username)
+		}
+	}
+	for _, t := range c.Tokens {
+		if t.Token == "" {
+			return fmt.Errorf("auth: token must not be empty")
+		}
+		if t.Scope != scopeRead && t.Scope != scopeControl {
+			return fmt.Errorf("auth: scope of the token must be either read or control")
+		}
+	}
+	return nil
+}
+
+// requiredScope returns the scope needed to serve the request. Control API is POST only.
+func requiredScope(r *http.Request) string {
+	if r.Method == http.MethodPost {
+		return scopeControl
+	}
+	return scopeRead
+}
+
+// checkPassword compares the password with bcrypt hash, remembering the successful result
+func checkPassword(username, password, hash string) bool {
+	sum := sha256.Sum256([]byte(password))
+	key := username + "\x00" + string(sum[:])
+
+	authCacheMu.Lock()
+	cached := authCache[key]
+	authCacheMu.Unlock()
+	if cached == hash {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	authCacheMu.Lock()
+	authCache[key] = hash
+	authCacheMu.Unlock()
+	return true
+}
+
+// authenticate returns scope granted by the request credentials, empty if there are none or they are wrong
+func authenticate(c authConfig, r *http.Request) string {
+	if username, password, ok := r.BasicAuth(); ok {
+		for _, u := range c.Users {
+			if u.Username == username && checkPassword(username, password, u.PasswordHash) {
+				return u.Scope
+			}
+		}
+		return ""
+	}
+
+	const bearerPrefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) > len(bearerPrefix) && header[:len(bearerPrefix)] == bearerPrefix {
+		token := []byte(header[len(bearerPrefix):])
+		for _, t := range c.Tokens {
+			if subtle.ConstantTimeCompare(token, []byte(t.Token)) == 1 {
+				return t.Scope
+			}
+		}
+	}
+	return ""
+}
+
+// authMiddleware rejects the requests without credentials granting the required scope
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		configMu.Lock()
+		c := config.Auth
+		configMu.Unlock()
+
+		if !c.enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scope := authenticate(c, r)
+		if scope == "" {
+			log.Debugf("Unauthorized HTTP request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="cameraleech"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if requiredScope(r) == scopeControl && scope != scopeControl {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hashPasswordCommand reads the password from stdin and prints its bcrypt hash for the auth config
+func hashPasswordCommand(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: echo password | cameraleech hash-password")
+		return 2
+	}
+
+	password, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "Password must be passed via stdin")
+		return 1
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(hash))
+	return 0
+}
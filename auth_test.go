@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("zabbixpass"), bcrypt.MinCost)
+	require.Nil(t, err)
+
+	configMu.Lock()
+	oldAuth := config.Auth
+	config.Auth = authConfig{
+		Users:  []authUser{{Username: "zabbix", PasswordHash: string(hash), Scope: scopeRead}},
+		Tokens: []authToken{{Token: "controltoken", Scope: scopeControl}},
+	}
+	require.Nil(t, validateAuthConfig(config.Auth))
+	configMu.Unlock()
+	defer func() {
+		configMu.Lock()
+		config.Auth = oldAuth
+		configMu.Unlock()
+	}()
+
+	router := newRouter()
+	req := httptest.NewRequest("GET", "/cameras.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+	assert.Equal(t, `Basic realm="cameraleech"`, w.Header().Get("WWW-Authenticate"))
+
+	req = httptest.NewRequest("GET", "/cameras.json", nil)
+	req.SetBasicAuth("zabbix", "wrongpass")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	req = httptest.NewRequest("GET", "/cameras.json", nil)
+	req.SetBasicAuth("zabbix", "zabbixpass")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	// Read-only user can't use the control API
+	req = httptest.NewRequest("POST", "/camera/nosuchcam/stop", nil)
+	req.SetBasicAuth("zabbix", "zabbixpass")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 403, w.Code)
+
+	req = httptest.NewRequest("POST", "/camera/nosuchcam/stop", nil)
+	req.Header.Set("Authorization", "Bearer controltoken")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestValidateAuthConfig(t *testing.T) {
+	assert.NotNil(t, validateAuthConfig(authConfig{Users: []authUser{{Username: "u", PasswordHash: "plaintext", Scope: scopeRead}}}))
+	assert.NotNil(t, validateAuthConfig(authConfig{Tokens: []authToken{{Token: "t", Scope: "admin"}}}))
+	assert.Nil(t, validateAuthConfig(authConfig{Tokens: []authToken{{Token: "t", Scope: scopeRead}}}))
+}
+
+func TestAuthSurvivesBrokenReload(t *testing.T) {
+	require.Nil(t, readConfig("tests/goodconfig_auth.toml"))
+	assert.NotNil(t, readConfig("tests/badconfig_syntax.toml"))
+
+	configMu.Lock()
+	assert.Len(t, config.Auth.Tokens, 1)
+	assert.Contains(t, config.Cameras, "cam1")
+	configMu.Unlock()
+
+	router := newRouter()
+	req := httptest.NewRequest("GET", "/cameras.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	req = httptest.NewRequest("GET", "/cameras.json", nil)
+	req.Header.Set("Authorization", "Bearer controltoken")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/go-cmd/cmd"
+	log "github.com/sirupsen/logrus"
+)
+
+// recordExit stores the ffmpeg exit information and updates the crash loop state
+// unless ffmpeg has been stopped by the stall watchdog.
+// It returns the delay to wait before the next restart.
+func (l *leech) recordExit(status cmd.Status) time.Duration {
+	l.stateMu.Lock()
+	defer l.stateMu.Unlock()
+
+	now := time.Now()
+	l.lastExitCode = status.Exit
+	l.lastExitTime = now
+	l.recovering = true
+
+	// Restarts caused by the stall watchdog are not crashes, so they don't affect the backoff
+	if l.stallKill {
+		l.stallKill = false
+		return time.Duration(l.Config.RestartDelayMin) * time.Second
+	}
+
+	// ffmpeg which has been running long enough is considered healthy, so the backoff starts over
+	window := time.Duration(l.Config.CrashLoopWindow) * time.Second
+	if now.Sub(l.startedAt) >= window {
+		l.restartAttempt = 0
+	}
+
+	restartTimes := l.restartTimes[:0]
+	for _, t := range l.restartTimes {
+		if now.Sub(t) < window {
+			restartTimes = append(restartTimes, t)
+		}
+	}
+	l.restartTimes = append(restartTimes, now)
+
+	if len(l.restartTimes) >= l.Config.CrashLoopRestarts {
+		if !l.crashLoop {
+			log.Errorf("Camera %s: crash loop detected, %d restarts within %d seconds", l.Config.Name, len(l.restartTimes), l.Config.CrashLoopWindow)
+		}
+		l.crashLoop = true
+	} else if l.crashLoop {
+		log.Infof("Camera %s: left crash loop state", l.Config.Name)
+		l.crashLoop = false
+	}
+
+	return l.nextRestartDelay()
+}
+
+// nextRestartDelay returns exponentially growing delay with jitter, capped by restartDelayMax.
+// Must be called with stateMu held.
+func (l *leech) nextRestartDelay() time.Duration {
+	minDelay := time.Duration(l.Config.RestartDelayMin) * time.Second
+	maxDelay := time.Duration(l.Config.RestartDelayMax) * time.Second
+
+	delay := maxDelay
+	if !l.crashLoop && l.restartAttempt < 32 {
+		delay = minDelay << uint(l.restartAttempt)
+		if delay < minDelay || delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+	l.restartAttempt++
+
+	if l.Config.RestartJitter > 0 {
+		jitter := float64(delay) * float64(l.Config.RestartJitter) / 100
+		delay += time.Duration((rand.Float64()*2 - 1) * jitter)
+	}
+	return delay
+}
+
+func (l *leech) getRestartState() (lastExitCode int, lastExitTime time.Time, crashLoop bool) {
+	l.stateMu.Lock()
+	defer l.stateMu.Unlock()
+	return l.lastExitCode, l.lastExitTime, l.crashLoop
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-cmd/cmd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	l := newLeech(cameraConfig{
+		Name:              "cam1",
+		RestartDelayMin:   1,
+		RestartDelayMax:   10,
+		CrashLoopRestarts: 5,
+		CrashLoopWindow:   300,
+	})
+	l.startedAt = time.Now()
+
+	expected := []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}
+	for i, e := range expected {
+		delay := l.recordExit(cmd.Status{Exit: 1})
+		assert.Equal(t, e, delay, "restart %d", i)
+	}
+
+	code, exitTime, crashLoop := l.getRestartState()
+	assert.Equal(t, 1, code)
+	assert.False(t, exitTime.IsZero())
+	assert.True(t, crashLoop)
+
+	// Healthy run resets the backoff and the crash loop state
+	l.restartTimes = nil
+	l.startedAt = time.Now().Add(-time.Hour)
+	delay := l.recordExit(cmd.Status{Exit: 0})
+	assert.Equal(t, 1*time.Second, delay)
+	_, _, crashLoop = l.getRestartState()
+	assert.False(t, crashLoop)
+}
+
+func TestRestartBackoffJitter(t *testing.T) {
+	l := newLeech(cameraConfig{
+		Name:              "cam1",
+		RestartDelayMin:   10,
+		RestartDelayMax:   10,
+		RestartJitter:     20,
+		CrashLoopRestarts: 5,
+		CrashLoopWindow:   300,
+	})
+
+	for i := 0; i < 100; i++ {
+		delay := l.nextRestartDelay()
+		assert.True(t, delay >= 8*time.Second && delay <= 12*time.Second, "delay %v is out of the jitter range", delay)
+	}
+}
+
+func TestRestartBackoffZeroSettings(t *testing.T) {
+	require.Nil(t, readConfig("tests/goodconfig_restart.toml"))
+	assert.Equal(t, 0, config.Cameras["cam1"].RestartDelayMin)
+	assert.Equal(t, 0, config.Cameras["cam1"].RestartJitter)
+	assert.Equal(t, 1, config.Cameras["cam2"].RestartDelayMin)
+	assert.Equal(t, 10, config.Cameras["cam2"].RestartJitter)
+
+	// Zero min delay restarts immediately until the crash loop
+	l := newLeech(config.Cameras["cam1"])
+	l.startedAt = time.Now()
+	for i := 0; i < config.Cameras["cam1"].CrashLoopRestarts-1; i++ {
+		assert.Equal(t, time.Duration(0), l.recordExit(cmd.Status{Exit: 1}))
+	}
+	assert.Equal(t, 300*time.Second, l.recordExit(cmd.Status{Exit: 1}))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/sys/unix"
+)
+
+type configProblem struct {
+	Warning bool // warnings don't make the config invalid
+	Message string
+}
+
+func (p configProblem) String() string {
+	if p.Warning {
+		return "warning: " + p.Message
+	}
+	return "error: " + p.Message
+}
+
+// checkConfig validates the config without starting anything. Unlike readConfig,
+// which refuses the whole config, it reports every problem found.
+func checkConfig(filePath string) []configProblem {
+	problems := make([]configProblem, 0)
+	addError := func(format string, a ...interface{}) {
+		problems = append(problems, configProblem{Message: fmt.Sprintf(format, a...)})
+	}
+	addWarning := func(format string, a ...interface{}) {
+		problems = append(problems, configProblem{Warning: true, Message: fmt.Sprintf(format, a...)})
+	}
+
+	// Misspelled keys are silently ignored by the decoder, so they are reported before the validation
+	var mainConfig tomlConfig
+	md, err := toml.DecodeFile(filePath, &mainConfig)
+	if err != nil {
+		addError("%v", err)
+		return problems
+	}
+	for _, key := range md.Undecoded() {
+		addError("unknown setting \"%s\"", key.String())
+	}
+	files, err := includedFiles(mainConfig, filePath)
+	if err != nil {
+		addError("%v", err)
+	}
+	for _, f := range files {
+		md, err := toml.DecodeFile(f, &includedConfig{})
+		if err != nil {
+			addError("%s: %v", f, err)
+			continue
+		}
+		for _, key := range md.Undecoded() {
+			addError("%s: unknown setting \"%s\"", f, key.String())
+		}
+	}
+
+	c, errs := parseConfig(filePath)
+	for _, err := range errs {
+		addError("%v", err)
+	}
+
+	names := make([]string, 0, len(c.Cameras))
+	for name := range c.Cameras {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	urls := make(map[string]string)
+	for _, name := range names {
+		cam := c.Cameras[name]
+
+		if err := checkExecutable(cam.FfmpegPath); err != nil {
+			addError("camera %s: ffmpeg: %v", name, err)
+		}
+
+		if err := checkWritable(cam.StoragePath); err != nil {
+			addError("camera %s: storage path: %v", name, err)
+		}
+
+		u, err := url.Parse(cam.URL)
+		if err != nil {
+			addError("camera %s: %v", name, maskCredentials(err.Error()))
+		} else if u.Scheme == "" || u.Host == "" {
+			addError("camera %s: URL %s must have scheme and host", name, maskCredentials(cam.URL))
+		}
+
+		inputURL, _ := ffmpegInputURL(cam)
+		if other, ok := urls[inputURL]; ok {
+			addWarning("camera %s: the same URL as camera %s", name, other)
+		} else {
+			urls[inputURL] = name
+		}
+
+		if err := checkInputOptions(cam.InputOptions); err != nil {
+			addError("camera %s: inputOptions: %v", name, err)
+		}
+
+		if cam.SegmentTime < 0 {
+			addError("camera %s: segmentTime must be positive", name)
+		} else if cam.SegmentTime < 10 || cam.SegmentTime > 86400 {
+			addWarning("camera %s: segmentTime %d is out of sane range 10..86400 seconds", name, cam.SegmentTime)
+		}
+
+		if cam.StallTimeout > 0 && cam.StallTimeout < 10 {
+			addWarning("camera %s: stallTimeout %d is likely too short for the stream to start", name, cam.StallTimeout)
+		}
+	}
+	return problems
+}
+
+// checkExecutable makes sure the file exists and can be executed
+func checkExecutable(path string) error {
+	if path == "" {
+		return fmt.Errorf("path is not set")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if err := unix.Access(path, unix.X_OK); err != nil {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
+// checkWritable makes sure the folder or its nearest existing parent (the folders are created on start) is writable
+func checkWritable(path string) error {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		fi, err := os.Stat(dir)
+		if os.IsNotExist(err) && dir != filepath.Dir(dir) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		if err := unix.Access(dir, unix.W_OK); err != nil {
+			return fmt.Errorf("%s is not writable", dir)
+		}
+		return nil
+	}
+}
+
+// checkInputOptions makes sure the options survive splitting by whitespace the way ffmpeg command is assembled
+func checkInputOptions(options string) error {
+	if strings.ContainsAny(options, "\"'\\") {
+		return fmt.Errorf("quotes and escapes are not supported, options are split by whitespace")
+	}
+	args := regexp.MustCompile("\\s+").Split(strings.TrimSpace(options), -1)
+	if len(args) > 0 && args[0] != "" && !strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("\"%s\" is not an option", args[0])
+	}
+	return nil
+}
+
+func checkConfigCommand(args []string) int {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	flags.Parse(args)
+
+	problems := checkConfig(configPath)
+	errors := 0
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+		if !p.Warning {
+			errors++
+		}
+	}
+	if errors > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d errors, %d warnings\n", configPath, errors, len(problems)-errors)
+		return 1
+	}
+	fmt.Printf("%s: config is valid (%d warnings)\n", configPath, len(problems))
+	return 0
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfig(t *testing.T) {
+	assert.Empty(t, checkConfig("tests/goodconfig_fakeffmpeg.toml"))
+
+	messages := make([]string, 0)
+	for _, p := range checkConfig("tests/badconfig_check.toml") {
+		messages = append(messages, p.String())
+	}
+	assert.ElementsMatch(t, []string{
+		"error: unknown setting \"cameras.cam1.segmentTme\"",
+		"error: tests/include_check.d/site.toml: unknown setting \"cameras.cam5.retentionDay\"",
+		"error: Camera cam3: container must be one of the following: mkv, mp4, fmp4, mpegts",
+		"error: You have to specify URL for camera cam4",
+		"error: camera cam1: ffmpeg: tests/goodconfig.toml is not executable",
+		"error: camera cam2: ffmpeg: tests/goodconfig.toml is not executable",
+		"error: camera cam5: ffmpeg: tests/goodconfig.toml is not executable",
+		"warning: camera cam2: the same URL as camera cam1",
+		"error: camera cam2: inputOptions: quotes and escapes are not supported, options are split by whitespace",
+		"warning: camera cam2: segmentTime 5 is out of sane range 10..86400 seconds",
+	}, messages)
+
+	assert.Contains(t, checkConfig("tests/badconfig_missingurl.toml"), configProblem{Message: "You have to specify URL for camera cam2"})
+	assert.Len(t, checkConfig("tests/badconfig_syntax.toml"), 1)
+}
+
+func TestCheckInputOptions(t *testing.T) {
+	assert.Nil(t, checkInputOptions(""))
+	assert.Nil(t, checkInputOptions("-stimeout 60000000 -rtsp_transport tcp"))
+	assert.NotNil(t, checkInputOptions("stimeout 60000000"))
+	assert.NotNil(t, checkInputOptions("-headers \"User-Agent: x\""))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Subcommands are run instead of the recorder: cameraleech [-config path] <command> [command flags]
+var subcommands = map[string]func(args []string) int{
+	"check-config":  checkConfigCommand,
+	"export":        exportCommand,
+	"hash-password": hashPasswordCommand,
+	"probe":         probeCommand,
+}
+
+func subcommandNames() string {
+	names := make([]string, 0, len(subcommands))
+	for k := range subcommands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func runSubcommand(args []string) int {
+	command, ok := subcommands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command \"%s\". Available commands: %s\n", args[0], subcommandNames())
+		return 2
+	}
+	return command(args[1:])
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config path] [command [command flags]]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "Without command the recorder is started. Available commands: %s\n", subcommandNames())
+	flag.PrintDefaults()
+}
@@ -5,7 +5,11 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 
@@ -21,30 +25,68 @@ var (
 )
 
 type tomlConfig struct {
-	httpListenAddress string
-	Loglevel          string
-	DisableHints      bool
-	Defaults          cameraConfig
-	Cameras           map[string]cameraConfig
+	HTTPListenAddress      listenAddresses
+	HTTPSListenAddress     listenAddresses
+	TLSCertFile            string
+	TLSKeyFile             string
+	TLSClientCAFile        string
+	Auth                   authConfig
+	Loglevel               string
+	DisableHints           bool
+	FreeSpaceLowWatermark  int
+	FreeSpaceHighWatermark int
+	HookWorkers            int
+	HookTimeout            int
+	Webhooks               []string
+	MQTT                   mqttConfig
+	Zabbix                 zabbixConfig
+	Include                []string // glob patterns of files with more cameras, e.g. conf.d/*.toml
+	Defaults               cameraConfig
+	Cameras                map[string]cameraConfig
 }
 
 type cameraConfig struct {
-	Name           string
-	FfmpegPath     string
-	FfmpegLogLevel string
-	StoragePath    string
-	SegmentTime    int
-	InputOptions   string
-	URL            string
+	Name           string `json:"name"`
+	FfmpegPath     string `json:"ffmpegPath"`
+	FfmpegLogLevel string `json:"ffmpegLogLevel"`
+	StoragePath    string `json:"storagePath"`
+	SegmentTime    int    `json:"segmentTime"`
+	InputOptions   string `json:"inputOptions"`
+	URL            string `json:"url"`
+
+	// Credentials injected into the URL passed to ffmpeg, so that they don't have to be in the URL
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"passwordFile"`
+
+	Container        string `json:"container"`
+	FilenameTemplate string `json:"filenameTemplate"`
+
+	RetentionDays   int   `json:"retentionDays"`
+	MaxStorageBytes int64 `json:"maxStorageBytes"`
+
+	RestartDelayMin   int `json:"restartDelayMin"`
+	RestartDelayMax   int `json:"restartDelayMax"`
+	RestartJitter     int `json:"restartJitter"`
+	CrashLoopRestarts int `json:"crashLoopRestarts"`
+	CrashLoopWindow   int `json:"crashLoopWindow"`
+
+	StallTimeout int `json:"stallTimeout"`
+
+	SnapshotInterval int  `json:"snapshotInterval"`
+	LiveHLS          bool `json:"liveHLS"`
+
+	OnSegmentComplete []string `json:"onSegmentComplete"`
 }
 
 func parseFlags() {
 	flag.StringVar(&configPath, "config", "/etc/cameraleech.toml", "Path to the config file")
+	flag.Usage = usage
 	flag.Parse()
 }
 
 func identicalConfigAndLeech(s cameraConfig, l *leech) bool {
-	if s == l.Config {
+	if reflect.DeepEqual(s, l.Config) {
 		return true
 	}
 	return false
@@ -75,6 +117,7 @@ func launchLeeches() error {
 			continue
 		}
 		delete(leeches, k)
+		setCameraPaused(k, false)
 
 	}
 
@@ -94,6 +137,12 @@ func launchLeeches() error {
 		l := newLeech(config.Cameras[k])
 		l.Config = val
 
+		if isCameraPaused(k) {
+			log.Infof("Camera %s is stopped via HTTP API, not starting it", k)
+			leeches[k] = l
+			continue
+		}
+
 		if err := l.Start(); err != nil {
 			log.Errorf("Error starting camera %s: %v", k, err)
 			continue
@@ -111,93 +160,380 @@ func launchLeeches() error {
 		if identicalConfigAndLeech(s, val) {
 			continue
 		}
-		val.Config = s
 		val.Stop()
-		val.Start()
+		val.Config = s
+		if isCameraPaused(k) {
+			continue
+		}
+		if err := val.Start(); err != nil {
+			log.Errorf("Error restarting camera %s: %v", k, err)
+		}
 	}
 	return nil
 }
 
+// definedKeys returns the keys set in the config file. Setting names are lowercased
+// as the decoder matches them case-insensitively, camera names are kept as is
+func definedKeys(md toml.MetaData) map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range md.Keys() {
+		k := make([]string, len(key))
+		for i := range key {
+			if i == 1 && strings.EqualFold(key[0], "cameras") {
+				k[i] = key[i]
+				continue
+			}
+			k[i] = strings.ToLower(key[i])
+		}
+		keys[strings.Join(k, ".")] = true
+	}
+	return keys
+}
+
+// cameraKey returns the key of the camera setting as returned by definedKeys
+func cameraKey(camName, setting string) string {
+	return "cameras." + camName + "." + strings.ToLower(setting)
+}
+
 func readConfig(filePath string) error {
 	configMu.Lock()
 	defer configMu.Unlock()
 
-	configText, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return err
-	}
-	config = tomlConfig{}
-	if _, err := toml.Decode(string(configText), &config); err != nil {
-		return err
+	// The new config replaces the current one only when it is valid, so a broken config doesn't
+	// disable authentication or other settings on reload
+	newConfig, errs := parseConfig(filePath)
+	if len(errs) > 0 {
+		messages := make([]string, 0, len(errs))
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return errors.New(strings.Join(messages, "; "))
 	}
 
-	if config.httpListenAddress == "" {
-		config.httpListenAddress = "127.0.0.1:8080"
-	}
+	logLevel, _ := parseLogLevel(newConfig.Loglevel)
+	log.SetLevel(logLevel)
+	config = newConfig
+	return nil
+}
 
-	// выставляем уровень логирования
-	switch config.Loglevel {
+// parseLogLevel converts the config log level, warn is the default
+func parseLogLevel(level string) (log.Level, error) {
+	switch level {
 	case "fatal":
-		log.SetLevel(log.FatalLevel)
+		return log.FatalLevel, nil
 	case "error":
-		log.SetLevel(log.ErrorLevel)
-	case "warn":
-		log.SetLevel(log.WarnLevel)
+		return log.ErrorLevel, nil
+	case "warn", "":
+		return log.WarnLevel, nil
 	case "info":
-		log.SetLevel(log.InfoLevel)
+		return log.InfoLevel, nil
 	case "debug":
-		log.SetLevel(log.DebugLevel)
-	case "":
-		log.SetLevel(log.WarnLevel)
-	default:
-		return errors.New("Log level must be one of the following: fatal, error, warn, info, debug")
+		return log.DebugLevel, nil
+	}
+	return log.WarnLevel, errors.New("Log level must be one of the following: fatal, error, warn, info, debug")
+}
+
+// parseConfig reads the config with the included files and fills in the defaults.
+// It doesn't stop on the first invalid setting and returns all the errors found,
+// the cameras with errors are left out of the config.
+func parseConfig(filePath string) (tomlConfig, []error) {
+	newConfig := tomlConfig{}
+	configText, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return newConfig, []error{err}
+	}
+	md, err := toml.Decode(string(configText), &newConfig)
+	if err != nil {
+		return newConfig, []error{err}
+	}
+	defined := definedKeys(md)
+
+	// The files are globbed on every reload, so added and removed files are picked up on SIGHUP
+	if err := includeConfigFiles(&newConfig, filePath, defined); err != nil {
+		return newConfig, []error{err}
+	}
+
+	errs := make([]error, 0)
+
+	if len(newConfig.HTTPListenAddress) == 0 && len(newConfig.HTTPSListenAddress) == 0 {
+		newConfig.HTTPListenAddress = listenAddresses{"127.0.0.1:8080"}
+	}
+
+	if (newConfig.TLSCertFile == "") != (newConfig.TLSKeyFile == "") {
+		errs = append(errs, errors.New("Both tlsCertFile and tlsKeyFile must be specified to enable TLS"))
+	}
+
+	if len(newConfig.HTTPSListenAddress) > 0 && newConfig.TLSCertFile == "" {
+		errs = append(errs, errors.New("httpsListenAddress requires tlsCertFile and tlsKeyFile"))
+	}
+
+	// Configs written before httpsListenAddress existed enable TLS on httpListenAddress
+	if newConfig.TLSCertFile != "" && len(newConfig.HTTPSListenAddress) == 0 {
+		log.Warnf("tlsCertFile is set without httpsListenAddress, serving HTTPS on httpListenAddress. Please move the addresses to httpsListenAddress")
+		newConfig.HTTPSListenAddress = newConfig.HTTPListenAddress
+		newConfig.HTTPListenAddress = nil
 	}
 
-	if config.Defaults.FfmpegLogLevel == "" {
-		config.Defaults.FfmpegLogLevel = "repeat+level+error"
+	if err := validateAuthConfig(newConfig.Auth); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseLogLevel(newConfig.Loglevel); err != nil {
+		errs = append(errs, err)
+	}
+
+	if newConfig.FreeSpaceLowWatermark != 0 && newConfig.FreeSpaceHighWatermark == 0 {
+		newConfig.FreeSpaceHighWatermark = newConfig.FreeSpaceLowWatermark
+	}
+
+	if err := validateWatermarks(newConfig.FreeSpaceLowWatermark, newConfig.FreeSpaceHighWatermark); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Up to 4 segment hooks are run simultaneously, each for at most 5 minutes
+	if newConfig.HookWorkers == 0 {
+		newConfig.HookWorkers = 4
+	}
+
+	if newConfig.HookTimeout == 0 {
+		newConfig.HookTimeout = 300
+	}
+
+	if newConfig.HookWorkers < 0 || newConfig.HookTimeout < 0 {
+		errs = append(errs, errors.New("hookWorkers and hookTimeout must not be negative"))
+	}
+
+	if newConfig.MQTT.ClientID == "" {
+		newConfig.MQTT.ClientID = "cameraleech-" + hostname
+	}
+
+	if newConfig.MQTT.TopicPrefix == "" {
+		newConfig.MQTT.TopicPrefix = "cameraleech/" + hostname
+	}
+
+	// Zabbix sender pushes all the items every minute, 250 values per request
+	if newConfig.Zabbix.Host == "" {
+		newConfig.Zabbix.Host = hostname
+	}
+
+	if newConfig.Zabbix.Interval <= 0 {
+		newConfig.Zabbix.Interval = 60
+	}
+
+	if newConfig.Zabbix.BatchSize <= 0 {
+		newConfig.Zabbix.BatchSize = 250
+	}
+
+	if newConfig.Defaults.FfmpegLogLevel == "" {
+		newConfig.Defaults.FfmpegLogLevel = "repeat+level+error"
 	}
 
 	// Default segment time is 3600 seconds (1 hour)
-	if config.Defaults.SegmentTime == 0 {
-		config.Defaults.SegmentTime = 3600
+	if newConfig.Defaults.SegmentTime == 0 {
+		newConfig.Defaults.SegmentTime = 3600
+	}
+
+	if newConfig.Defaults.Container == "" {
+		newConfig.Defaults.Container = "mkv"
+	}
+
+	if newConfig.Defaults.FilenameTemplate == "" {
+		newConfig.Defaults.FilenameTemplate = defaultFilenameTemplate
+	}
+
+	// Restart backoff: 1 second doubling up to 5 minutes with 20% jitter. 0 is a valid min delay and jitter
+	if !defined["defaults.restartdelaymin"] {
+		newConfig.Defaults.RestartDelayMin = 1
+	}
+
+	if newConfig.Defaults.RestartDelayMax == 0 {
+		newConfig.Defaults.RestartDelayMax = 300
 	}
 
+	if !defined["defaults.restartjitter"] {
+		newConfig.Defaults.RestartJitter = 20
+	}
+
+	// Crash loop is 5 restarts within 5 minutes
+	if newConfig.Defaults.CrashLoopRestarts == 0 {
+		newConfig.Defaults.CrashLoopRestarts = 5
+	}
+
+	if newConfig.Defaults.CrashLoopWindow == 0 {
+		newConfig.Defaults.CrashLoopWindow = 300
+	}
+
+	// ffmpeg is restarted if no frames are received for 60 seconds
+	if newConfig.Defaults.StallTimeout == 0 {
+		newConfig.Defaults.StallTimeout = 60
+	}
+
+	// Sorted, so that the errors are reported in the same order every time
+	camNames := make([]string, 0, len(newConfig.Cameras))
+	for camName := range newConfig.Cameras {
+		camNames = append(camNames, camName)
+	}
+	sort.Strings(camNames)
+
 	// Расставляем дефолтные значения если не указано в камерах
-	for camName := range config.Cameras {
-		camConfig := config.Cameras[camName]
+	for _, camName := range camNames {
+		camConfig := newConfig.Cameras[camName]
 		camConfig.Name = camName
 
 		if camConfig.FfmpegPath == "" {
-			camConfig.FfmpegPath = config.Defaults.FfmpegPath
+			camConfig.FfmpegPath = newConfig.Defaults.FfmpegPath
 		}
 
 		if camConfig.FfmpegLogLevel == "" {
-			camConfig.FfmpegLogLevel = config.Defaults.FfmpegLogLevel
+			camConfig.FfmpegLogLevel = newConfig.Defaults.FfmpegLogLevel
 		}
 
 		if camConfig.StoragePath == "" {
-			camConfig.StoragePath = config.Defaults.StoragePath
+			camConfig.StoragePath = newConfig.Defaults.StoragePath
 		}
 
 		if camConfig.StoragePath == "" {
-			return fmt.Errorf("Camera %s: storage path must not be empty. Either specify the camera or default storage path", camName)
+			errs = append(errs, fmt.Errorf("Camera %s: storage path must not be empty. Either specify the camera or default storage path", camName))
+			delete(newConfig.Cameras, camName)
+			continue
 		}
 
 		if camConfig.SegmentTime == 0 {
-			camConfig.SegmentTime = config.Defaults.SegmentTime
+			camConfig.SegmentTime = newConfig.Defaults.SegmentTime
 		}
 
 		if camConfig.URL == "" {
-			return fmt.Errorf("You have to specify URL for camera %s", camConfig.Name)
+			errs = append(errs, fmt.Errorf("You have to specify URL for camera %s", camConfig.Name))
+			delete(newConfig.Cameras, camName)
+			continue
+		}
+
+		if camConfig.Username == "" {
+			camConfig.Username = newConfig.Defaults.Username
+		}
+
+		if camConfig.Password == "" {
+			camConfig.Password = newConfig.Defaults.Password
+		}
+
+		if camConfig.PasswordFile == "" {
+			camConfig.PasswordFile = newConfig.Defaults.PasswordFile
+		}
+
+		if camConfig.Password == "" && camConfig.PasswordFile != "" {
+			password, err := ioutil.ReadFile(camConfig.PasswordFile)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Camera %s: can not read password file: %v", camName, err))
+				delete(newConfig.Cameras, camName)
+				continue
+			}
+			camConfig.Password = strings.TrimSpace(string(password))
+		}
+
+		if camConfig.Username != "" {
+			u, err := url.Parse(camConfig.URL)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Camera %s: can not parse URL: %v", camName, maskCredentials(err.Error())))
+				delete(newConfig.Cameras, camName)
+				continue
+			}
+			if u.User != nil {
+				errs = append(errs, fmt.Errorf("Camera %s: credentials must be specified either in URL or in username and password", camName))
+				delete(newConfig.Cameras, camName)
+				continue
+			}
 		}
 
 		if camConfig.InputOptions == "" {
-			camConfig.InputOptions = config.Defaults.InputOptions
+			camConfig.InputOptions = newConfig.Defaults.InputOptions
+		}
+
+		if camConfig.Container == "" {
+			camConfig.Container = newConfig.Defaults.Container
+		}
+
+		if _, ok := containers[camConfig.Container]; !ok {
+			errs = append(errs, fmt.Errorf("Camera %s: container must be one of the following: mkv, mp4, fmp4, mpegts", camName))
+			delete(newConfig.Cameras, camName)
+			continue
+		}
+
+		if camConfig.FilenameTemplate == "" {
+			camConfig.FilenameTemplate = newConfig.Defaults.FilenameTemplate
 		}
 
-		config.Cameras[camName] = camConfig
+		if err := validateFilenameTemplate(camConfig.FilenameTemplate); err != nil {
+			errs = append(errs, fmt.Errorf("Camera %s: %v", camName, err))
+			delete(newConfig.Cameras, camName)
+			continue
+		}
+
+		if camConfig.RetentionDays == 0 {
+			camConfig.RetentionDays = newConfig.Defaults.RetentionDays
+		}
+
+		if camConfig.MaxStorageBytes == 0 {
+			camConfig.MaxStorageBytes = newConfig.Defaults.MaxStorageBytes
+		}
+
+		if camConfig.RetentionDays < 0 || camConfig.MaxStorageBytes < 0 {
+			errs = append(errs, fmt.Errorf("Camera %s: retentionDays and maxStorageBytes must not be negative", camName))
+			delete(newConfig.Cameras, camName)
+			continue
+		}
+
+		if !defined[cameraKey(camName, "restartDelayMin")] {
+			camConfig.RestartDelayMin = newConfig.Defaults.RestartDelayMin
+		}
+
+		if camConfig.RestartDelayMax == 0 {
+			camConfig.RestartDelayMax = newConfig.Defaults.RestartDelayMax
+		}
+
+		if !defined[cameraKey(camName, "restartJitter")] {
+			camConfig.RestartJitter = newConfig.Defaults.RestartJitter
+		}
+
+		if camConfig.CrashLoopRestarts == 0 {
+			camConfig.CrashLoopRestarts = newConfig.Defaults.CrashLoopRestarts
+		}
+
+		if camConfig.CrashLoopWindow == 0 {
+			camConfig.CrashLoopWindow = newConfig.Defaults.CrashLoopWindow
+		}
+
+		if camConfig.StallTimeout == 0 {
+			camConfig.StallTimeout = newConfig.Defaults.StallTimeout
+		}
+
+		// Cameras may opt out of the snapshots and live HLS enabled in defaults
+		if !defined[cameraKey(camName, "snapshotInterval")] {
+			camConfig.SnapshotInterval = newConfig.Defaults.SnapshotInterval
+		}
+
+		if !defined[cameraKey(camName, "liveHLS")] {
+			camConfig.LiveHLS = newConfig.Defaults.LiveHLS
+		}
+
+		if len(camConfig.OnSegmentComplete) == 0 {
+			camConfig.OnSegmentComplete = newConfig.Defaults.OnSegmentComplete
+		}
+
+		if camConfig.RestartDelayMin < 0 || camConfig.RestartDelayMax < camConfig.RestartDelayMin {
+			errs = append(errs, fmt.Errorf("Camera %s: restartDelayMin must not be negative and must not exceed restartDelayMax", camName))
+			delete(newConfig.Cameras, camName)
+			continue
+		}
+
+		if camConfig.RestartJitter < 0 || camConfig.RestartJitter > 100 {
+			errs = append(errs, fmt.Errorf("Camera %s: restartJitter must be between 0 and 100 percent", camName))
+			delete(newConfig.Cameras, camName)
+			continue
+		}
+
+		newConfig.Cameras[camName] = camConfig
 	}
-	return nil
+	return newConfig, errs
 }
 
 func signalWatcher(c chan os.Signal) {
@@ -208,6 +544,7 @@ func signalWatcher(c chan os.Signal) {
 			log.Info("Got termination signal")
 			programIsStopping = true
 			cond.Signal()
+			stopMQTT()
 
 			for k, l := range leeches {
 				log.Infof("Terminating camera %s", k)
@@ -225,7 +562,10 @@ func signalWatcher(c chan os.Signal) {
 			}
 			if err := launchLeeches(); err != nil {
 				log.Warnf("Error (re-)launching leeches: %v", err)
-				break
+			}
+			stopRemovedWebhooks(config.Webhooks)
+			if err := applyHTTPListeners(config); err != nil {
+				log.Errorf("Error rebinding HTTP listeners: %v", err)
 			}
 		}
 	}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// Cameras stopped via HTTP API. They stay stopped across config reloads until started again.
+var (
+	pausedCamerasMu sync.Mutex
+	pausedCameras   = make(map[string]bool)
+)
+
+func setCameraPaused(camName string, paused bool) {
+	pausedCamerasMu.Lock()
+	defer pausedCamerasMu.Unlock()
+
+	if paused {
+		pausedCameras[camName] = true
+		return
+	}
+	delete(pausedCameras, camName)
+}
+
+func isCameraPaused(camName string) bool {
+	pausedCamerasMu.Lock()
+	defer pausedCamerasMu.Unlock()
+	return pausedCameras[camName]
+}
+
+func cameraStop(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	log.Infof("Camera %s: stop requested via HTTP API", camName)
+	setCameraPaused(camName, true)
+	if err := leech.Stop(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "OK")
+}
+
+func cameraStart(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	log.Infof("Camera %s: start requested via HTTP API", camName)
+	setCameraPaused(camName, false)
+	if err := leech.Start(); err != nil {
+		if err == errLeechRunning {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "OK")
+}
+
+func cameraRestart(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	log.Infof("Camera %s: restart requested via HTTP API", camName)
+	setCameraPaused(camName, false)
+	if err := leech.Stop(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := leech.Start(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "OK")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigFakeFfmpeg = "tests/goodconfig_fakeffmpeg.toml"
+
+func TestControlAPI(t *testing.T) {
+	err := readConfig(testConfigFakeFfmpeg)
+	require.Nil(t, err)
+
+	defer deleteDownloadedData(t, config.Defaults.StoragePath)
+
+	err = launchLeeches()
+	require.Nil(t, err)
+
+	defer stopLeeches(t)
+
+	router := newRouter()
+	post := func(url string) int {
+		req := httptest.NewRequest("POST", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	assert.True(t, leeches["cam1"].isRunning())
+	assert.Equal(t, 409, post("/camera/cam1/start"))
+	assert.Equal(t, 404, post("/camera/cam3/stop"))
+
+	assert.Equal(t, 200, post("/camera/cam1/stop"))
+	assert.False(t, leeches["cam1"].isRunning())
+	assert.True(t, leeches["cam2"].isRunning())
+
+	// Stopped camera stays stopped after config reload
+	err = readConfig(testConfigFakeFfmpeg)
+	require.Nil(t, err)
+	err = launchLeeches()
+	require.Nil(t, err)
+	assert.False(t, leeches["cam1"].isRunning())
+
+	assert.Equal(t, 200, post("/camera/cam1/start"))
+	assert.True(t, leeches["cam1"].isRunning())
+
+	assert.Equal(t, 200, post("/camera/cam2/restart"))
+	assert.True(t, leeches["cam2"].isRunning())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Passwords are replaced with that string wherever they can be seen by the user
+const maskedPassword = "xxxxx"
+
+// Matches "scheme://user:" part of the URLs with password, the password itself and "@"
+var urlCredentialsRegexp = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^\s:/@]*:)[^\s/]*@`)
+
+func init() {
+	log.SetFormatter(&maskingFormatter{log.StandardLogger().Formatter})
+}
+
+// maskingFormatter masks the passwords of all the URLs in the log output
+type maskingFormatter struct {
+	log.Formatter
+}
+
+func (f *maskingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	out, err := f.Formatter.Format(entry)
+	if err != nil {
+		return out, err
+	}
+	return urlCredentialsRegexp.ReplaceAll(out, []byte("${1}"+maskedPassword+"@")), nil
+}
+
+// maskCredentials replaces the passwords of all the URLs in the text, e.g. in ffmpeg output
+func maskCredentials(s string) string {
+	return urlCredentialsRegexp.ReplaceAllString(s, "${1}"+maskedPassword+"@")
+}
+
+// maskCameraCredentials additionally masks the camera password wherever it occurs in the text.
+// Very short passwords are only masked in URLs, otherwise the text would become unreadable.
+func maskCameraCredentials(c cameraConfig, s string) string {
+	if len(c.Password) >= 4 {
+		s = strings.Replace(s, c.Password, maskedPassword, -1)
+	}
+	return maskCredentials(s)
+}
+
+// ffmpegInputURL returns camera URL with username and password injected, it must only be passed to ffmpeg
+func ffmpegInputURL(c cameraConfig) (string, error) {
+	if c.Username == "" {
+		return c.URL, nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return "", err
+	}
+	u.User = url.UserPassword(c.Username, c.Password)
+	return u.String(), nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+var errNoFootage = errors.New("No footage found for the requested time range")
+
+// HTTP exports are limited, since each of them reads the whole range from the disk
+const (
+	maxConcurrentExports = 2
+	maxExportDuration    = 24 * time.Hour
+)
+
+var exportSlots = make(chan struct{}, maxConcurrentExports)
+
+// writeConcatList writes ffmpeg concat demuxer script trimming the first and the last segments to the range
+func writeConcatList(path string, segments []jsonSegment) error {
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	for i, s := range segments {
+		fmt.Fprintf(&b, "file '%s'\n", strings.Replace(s.Path, "'", "'\\''", -1))
+		if i == 0 && s.Offset > 0 {
+			fmt.Fprintf(&b, "inpoint %.3f\n", s.Offset)
+		}
+		if i == len(segments)-1 && s.Offset+s.Length < s.Duration {
+			fmt.Fprintf(&b, "outpoint %.3f\n", s.Offset+s.Length)
+		}
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// exportClip cuts footage of the camera for the time range into the single file using stream copy.
+// ffmpeg is killed when the context is done
+func exportClip(ctx context.Context, c cameraConfig, from, to time.Time, outPath string) error {
+	segments, err := findSegments(c, from, to)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return errNoFootage
+	}
+
+	listFile, err := ioutil.TempFile("", "cameraleech-concat-")
+	if err != nil {
+		return err
+	}
+	listFile.Close()
+	defer os.Remove(listFile.Name())
+
+	if err := writeConcatList(listFile.Name(), segments); err != nil {
+		return err
+	}
+
+	ffmpegArgs := []string{"-hide_banner", "-nostdin", "-loglevel", "error",
+		"-f", "concat", "-safe", "0", "-i", listFile.Name(),
+		"-map", "0", "-codec", "copy", "-y", outPath}
+
+	log.Infof("Camera %s: exporting %d segments from %s to %s into %s", c.Name, len(segments), from, to, outPath)
+	out, err := exec.CommandContext(ctx, c.FfmpegPath, ffmpegArgs...).CombinedOutput()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func cameraExport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from) > maxExportDuration {
+		http.Error(w, fmt.Sprintf("Time range must not exceed %v", maxExportDuration), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case exportSlots <- struct{}{}:
+		defer func() { <-exportSlots }()
+	default:
+		http.Error(w, "Too many exports in progress, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	outFile, err := ioutil.TempFile("", "cameraleech-export-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	outFile.Close()
+	outPath := outFile.Name() + ".mkv"
+	os.Remove(outFile.Name())
+	defer os.Remove(outPath)
+
+	if err := exportClip(r.Context(), leech.Config, from, to, outPath); err != nil {
+		if err == errNoFootage {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if r.Context().Err() != nil {
+			log.Infof("Camera %s: export cancelled by the client", camName)
+			return
+		}
+		log.Errorf("Camera %s: export failed: %v", camName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(outPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	fileName := fmt.Sprintf("%s_%s.mkv", camName, from.Format(segmentNameLayout))
+	w.Header().Set("Content-Type", "video/x-matroska")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+	http.ServeContent(w, r, fileName, time.Now(), f)
+}
+
+// exportCommand implements "cameraleech export" subcommand
+func exportCommand(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	camName := flags.String("camera", "", "Camera name")
+	fromStr := flags.String("from", "", "Beginning of the clip: unix timestamp, RFC3339 or YYYY-MM-DDTHH:MM:SS")
+	toStr := flags.String("to", "", "End of the clip: unix timestamp, RFC3339 or YYYY-MM-DDTHH:MM:SS")
+	output := flags.String("output", "", "Output file (default: <camera>_<from>.mkv in the current directory)")
+	flags.Parse(args)
+
+	if *camName == "" || *fromStr == "" || *toStr == "" {
+		fmt.Fprintln(os.Stderr, "Usage: cameraleech [-config path] export -camera name -from time -to time [-output file]")
+		return 2
+	}
+
+	if err := readConfig(configPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Can not read config: %s\n", err)
+		return 1
+	}
+
+	c, ok := config.Cameras[*camName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Didn't find camera \"%s\" in the config\n", *camName)
+		return 1
+	}
+
+	from, err := parseTimeParam(*fromStr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	to, err := parseTimeParam(*toStr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !from.Before(to) {
+		fmt.Fprintln(os.Stderr, "\"from\" must be earlier than \"to\"")
+		return 1
+	}
+
+	if *output == "" {
+		*output = fmt.Sprintf("%s_%s.mkv", *camName, from.Format(segmentNameLayout))
+	}
+	if err := os.MkdirAll(filepath.Dir(*output), 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := exportClip(context.Background(), c, from, to, *output); err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return 1
+	}
+	fmt.Println(*output)
+	return 0
+}
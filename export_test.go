@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteConcatList(t *testing.T) {
+	listFile, err := ioutil.TempFile("", "cameraleech-concat-")
+	require.Nil(t, err)
+	listFile.Close()
+	defer deleteDownloadedData(t, listFile.Name())
+
+	segments := []jsonSegment{
+		{Path: "/storage/cam1/2019-10-20/2019-10-20_14-00-00.mkv", Duration: 600, Offset: 180, Length: 420},
+		{Path: "/storage/cam1/2019-10-20/2019-10-20_14-10-00.mkv", Duration: 600, Offset: 0, Length: 600},
+		{Path: "/storage/cam'1/2019-10-20/2019-10-20_14-20-00.mkv", Duration: 600, Offset: 0, Length: 60.5},
+	}
+	err = writeConcatList(listFile.Name(), segments)
+	require.Nil(t, err)
+
+	content, err := ioutil.ReadFile(listFile.Name())
+	require.Nil(t, err)
+	assert.Equal(t, "ffconcat version 1.0\n"+
+		"file '/storage/cam1/2019-10-20/2019-10-20_14-00-00.mkv'\n"+
+		"inpoint 180.000\n"+
+		"file '/storage/cam1/2019-10-20/2019-10-20_14-10-00.mkv'\n"+
+		"file '/storage/cam'\\''1/2019-10-20/2019-10-20_14-20-00.mkv'\n"+
+		"outpoint 60.500\n", string(content))
+}
+
+func TestCameraExport(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	start := time.Date(2019, 10, 20, 14, 0, 0, 0, time.Local)
+	for i := 0; i < 2; i++ {
+		segStart := start.Add(time.Duration(i) * 10 * time.Minute)
+		name := segStart.Format(segmentNameLayout) + ".mkv"
+		createTestSegment(t, filepath.Join(storagePath, "cam1", segStart.Format("2006-01-02"), name), 100, segStart.Add(10*time.Minute))
+	}
+
+	leeches = map[string]*leech{"cam1": newLeech(cameraConfig{
+		Name:             "cam1",
+		FfmpegPath:       "tests/fake_export.sh",
+		StoragePath:      storagePath,
+		Container:        "mkv",
+		FilenameTemplate: defaultFilenameTemplate,
+	})}
+	defer func() { leeches = make(map[string]*leech) }()
+
+	router := newRouter()
+	code, body := testGet(t, router, "/camera/cam1/export?from=2019-10-20T14:05:00&to=2019-10-20T14:15:00")
+	require.Equal(t, 200, code, body)
+	assert.True(t, strings.HasPrefix(body, "ffconcat version 1.0\n"))
+	assert.Contains(t, body, "2019-10-20_14-10-00.mkv")
+
+	code, _ = testGet(t, router, "/camera/cam2/export?from=2019-10-20T14:05:00&to=2019-10-20T14:15:00")
+	assert.Equal(t, 404, code)
+	code, _ = testGet(t, router, "/camera/cam1/export?from=2019-10-19T14:05:00&to=2019-10-19T14:15:00")
+	assert.Equal(t, 404, code)
+	code, _ = testGet(t, router, "/camera/cam1/export?from=2019-10-18T14:05:00&to=2019-10-20T14:15:00")
+	assert.Equal(t, 400, code)
+
+	// All the export slots are busy
+	for i := 0; i < maxConcurrentExports; i++ {
+		exportSlots <- struct{}{}
+	}
+	code, _ = testGet(t, router, "/camera/cam1/export?from=2019-10-20T14:05:00&to=2019-10-20T14:15:00")
+	assert.Equal(t, 503, code)
+	for i := 0; i < maxConcurrentExports; i++ {
+		<-exportSlots
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// Amount of the last ffmpeg stderr lines kept for /camera/{name}/log
+const logRingSize = 200
+
+// Category of the stderr lines which don't match any known problem
+const logCategoryOther = "other"
+
+type logCategory struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Known problems reported by ffmpeg, the first matching category wins
+var logCategories = []logCategory{
+	{"dns", regexp.MustCompile(`(?i)failed to resolve hostname|name or service not known|name resolution`)},
+	{"connection_refused", regexp.MustCompile(`(?i)connection refused|no route to host|network is unreachable`)},
+	{"unauthorized", regexp.MustCompile(`(?i)401 unauthorized|403 forbidden|unauthorized|authorization failed`)},
+	{"timeout", regexp.MustCompile(`(?i)timed out|timeout`)},
+	{"disk_full", regexp.MustCompile(`(?i)no space left on device|disk quota exceeded`)},
+	{"codec", regexp.MustCompile(`(?i)non[- ]monoton\w* .*dts|invalid data found|error while decoding|missing picture|corrupt`)},
+}
+
+type logLine struct {
+	Time     time.Time
+	Category string
+	Text     string
+}
+
+// ffmpegLog keeps the last ffmpeg stderr lines and counts them by category
+type ffmpegLog struct {
+	mu     sync.Mutex
+	lines  [logRingSize]logLine
+	next   int // ring position the next line is written to
+	total  uint64
+	counts map[string]uint64
+}
+
+// classifyLogLine returns category of the ffmpeg stderr line
+func classifyLogLine(s string) string {
+	for _, c := range logCategories {
+		if c.re.MatchString(s) {
+			return c.name
+		}
+	}
+	return logCategoryOther
+}
+
+func (f *ffmpegLog) add(line logLine) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.counts == nil {
+		f.counts = make(map[string]uint64)
+	}
+	f.counts[line.Category]++
+	f.lines[f.next] = line
+	f.next = (f.next + 1) % logRingSize
+	f.total++
+}
+
+// last returns up to n last lines from the oldest to the newest
+func (f *ffmpegLog) last(n int) []logLine {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if uint64(n) > f.total {
+		n = int(f.total)
+	}
+	if n > logRingSize {
+		n = logRingSize
+	}
+	lines := make([]logLine, 0, n)
+	for i := n; i > 0; i-- {
+		lines = append(lines, f.lines[(f.next-i+logRingSize)%logRingSize])
+	}
+	return lines
+}
+
+// errorCounts returns amount of lines of every category, including the ones never seen
+func (f *ffmpegLog) errorCounts() map[string]uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counts := make(map[string]uint64, len(logCategories)+1)
+	for _, c := range logCategories {
+		counts[c.name] = f.counts[c.name]
+	}
+	counts[logCategoryOther] = f.counts[logCategoryOther]
+	return counts
+}
+
+// lastLogLine returns the last line ffmpeg has written to stderr
+func (l *leech) lastLogLine() string {
+	if lines := l.stderrLog.last(1); len(lines) > 0 {
+		return lines[0].Text
+	}
+	return ""
+}
+
+// sendLog logs ffmpeg stderr line. Passwords are masked, so that they don't get to the HTTP API and notifications.
+func (l *leech) sendLog(str string) {
+	str = maskCameraCredentials(l.Config, str)
+	category := classifyLogLine(str)
+	l.stderrLog.add(logLine{Time: time.Now(), Category: category, Text: str})
+	if category == logCategoryOther {
+		log.Infof("%s ffmpeg output: %s", l.Config.Name, str)
+		return
+	}
+	log.Warnf("%s ffmpeg output (%s): %s", l.Config.Name, category, str)
+}
+
+func cameraLog(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	n := logRingSize
+	if linesStr := r.FormValue("lines"); linesStr != "" {
+		var err error
+		if n, err = strconv.Atoi(linesStr); err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("Bad lines count \"%s\"", linesStr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range leech.stderrLog.last(n) {
+		fmt.Fprintf(w, "%s [%s] %s\n", line.Time.Format(time.RFC3339), line.Category, line.Text)
+	}
+}
+
+func cameraErrors(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	count, ok := leech.stderrLog.errorCounts()[vars["category"]]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown error category \"%s\"", vars["category"]), http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "%d", count)
+}
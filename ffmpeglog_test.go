@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyLogLine(t *testing.T) {
+	assert.Equal(t, "connection_refused", classifyLogLine("[tcp @ 0x55] Connection to tcp://10.0.0.1:554?timeout=0 failed: Connection refused"))
+	assert.Equal(t, "unauthorized", classifyLogLine("[rtsp @ 0x55] method DESCRIBE failed: 401 Unauthorized"))
+	assert.Equal(t, "timeout", classifyLogLine("rtsp://10.0.0.1: Connection timed out"))
+	assert.Equal(t, "codec", classifyLogLine("[segment @ 0x55] Non-monotonous DTS in output stream 0:0; previous: 100, current: 90"))
+	assert.Equal(t, "codec", classifyLogLine("Application provided invalid, non monotonically increasing dts to muxer in stream 0"))
+	assert.Equal(t, "disk_full", classifyLogLine("av_interleaved_write_frame(): No space left on device"))
+	assert.Equal(t, "other", classifyLogLine("Guessed Channel Layout for Input Stream #0.1 : mono"))
+}
+
+func TestCameraLog(t *testing.T) {
+	l := newLeech(cameraConfig{Name: "cam1"})
+	leeches = map[string]*leech{"cam1": l}
+	defer func() { leeches = nil }()
+
+	for i := 0; i < logRingSize+5; i++ {
+		l.sendLog(fmt.Sprintf("line %d", i))
+	}
+	l.sendLog("method DESCRIBE failed: 401 Unauthorized")
+
+	assert.Equal(t, "method DESCRIBE failed: 401 Unauthorized", l.lastLogLine())
+	assert.Equal(t, uint64(1), l.stderrLog.errorCounts()["unauthorized"])
+	assert.Equal(t, uint64(logRingSize+5), l.stderrLog.errorCounts()["other"])
+
+	router := newRouter()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/camera/cam1/log?lines=2", nil))
+	body, err := ioutil.ReadAll(w.Result().Body)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], fmt.Sprintf("[other] line %d", logRingSize+4)))
+	assert.True(t, strings.HasSuffix(lines[1], "[unauthorized] method DESCRIBE failed: 401 Unauthorized"))
+
+	// Only the last logRingSize lines are kept
+	assert.Len(t, l.stderrLog.last(1000), logRingSize)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/camera/cam1/errors/unauthorized", nil))
+	body, err = ioutil.ReadAll(w.Result().Body)
+	require.Nil(t, err)
+	assert.Equal(t, "1", string(body))
+}
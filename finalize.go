@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// finalizeSegment renames the segment reported complete by ffmpeg segment list to its final name.
+// ffmpeg reports only the base name, so the folder is looked up among the ones recent segments could be written to.
+func (l *leech) finalizeSegment(name string) {
+	c := l.Config
+	if filepath.IsAbs(name) {
+		renameSegment(c, name)
+		return
+	}
+
+	now := time.Now()
+	tried := make(map[string]bool)
+	for i := 0; i <= 2; i++ {
+		dir := segmentDir(c, now.Add(-time.Duration(i*c.SegmentTime)*time.Second))
+		if tried[dir] {
+			continue
+		}
+		tried[dir] = true
+
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			renameSegment(c, path)
+			return
+		}
+	}
+	log.Warnf("Camera %s: didn't find complete segment %s to finalize", c.Name, name)
+}
+
+// finalizePartialSegments renames all partial segments of the camera. Must be called when ffmpeg isn't running.
+func finalizePartialSegments(c cameraConfig) {
+	segments, err := listCameraSegments(c)
+	if err != nil {
+		log.Errorf("Camera %s: failed to list segments: %v", c.Name, err)
+		return
+	}
+	for _, s := range segments {
+		if s.Partial {
+			renameSegment(c, s.Path)
+		}
+	}
+}
+
+func renameSegment(c cameraConfig, partialPath string) {
+	finalPath := strings.TrimSuffix(partialPath, partialSuffix)
+	if err := os.Rename(partialPath, finalPath); err != nil {
+		// The segment could be finalized concurrently by the output grabber
+		if !os.IsNotExist(err) {
+			log.Errorf("Camera %s: failed to finalize segment %s: %v", c.Name, partialPath, err)
+		}
+		return
+	}
+	log.Debugf("Camera %s: segment %s is complete", c.Name, finalPath)
+	addSegmentWritten(c.Name)
+	renameCatalogSegment(c.Name, partialPath, finalPath)
+	runSegmentHooks(c, finalPath)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinalizeSegment(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	c := cameraConfig{Name: "cam1", StoragePath: storagePath, SegmentTime: 600, Container: "mkv", FilenameTemplate: defaultFilenameTemplate}
+	now := time.Now()
+	prevStart := now.Add(-10 * time.Minute).Truncate(time.Second)
+	curStart := now.Truncate(time.Second)
+	prevPath := filepath.Join(segmentDir(c, prevStart), prevStart.Format(segmentNameLayout)+".mkv")
+	curPath := filepath.Join(segmentDir(c, curStart), curStart.Format(segmentNameLayout)+".mkv")
+	createTestSegment(t, prevPath+partialSuffix, 100, now)
+	createTestSegment(t, curPath+partialSuffix, 100, now)
+
+	// Partial segments are listed but flagged
+	segments, err := listCameraSegments(c)
+	require.Nil(t, err)
+	require.Len(t, segments, 2)
+	assert.True(t, segments[0].Partial)
+	assert.True(t, segments[0].Start.Equal(prevStart))
+
+	// ffmpeg reports base name of the complete segment
+	written := getSegmentStat(c.Name).SegmentsWritten
+	l := newLeech(c)
+	l.finalizeSegment(filepath.Base(prevPath) + partialSuffix)
+	assert.Equal(t, written+1, getSegmentStat(c.Name).SegmentsWritten)
+	// The scan doesn't count the segments
+	require.Nil(t, updateSegmentStat(c))
+	assert.Equal(t, written+1, getSegmentStat(c.Name).SegmentsWritten)
+	_, err = os.Stat(prevPath)
+	assert.Nil(t, err)
+	_, err = os.Stat(curPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// Leftovers of the previous run are finalized before ffmpeg starts
+	finalizePartialSegments(c)
+	_, err = os.Stat(curPath)
+	assert.Nil(t, err)
+	assert.Equal(t, written+2, getSegmentStat(c.Name).SegmentsWritten)
+	segments, err = listCameraSegments(c)
+	require.Nil(t, err)
+	require.Len(t, segments, 2)
+	assert.False(t, segments[1].Partial)
+}
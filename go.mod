@@ -4,9 +4,13 @@ go 1.12
 
 require (
 	github.com/BurntSushi/toml v0.3.1
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/go-cmd/cmd v1.0.5
 	github.com/go-test/deep v1.0.3 // indirect
 	github.com/gorilla/mux v1.7.3
 	github.com/sirupsen/logrus v1.4.2
 	github.com/stretchr/testify v1.4.0
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859 // indirect
+	golang.org/x/sys v0.0.0-20190422165155-953cdadca894
 )
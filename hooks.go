@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Amount of hooks waiting for a free worker. When the queue is full, new hooks are dropped.
+const hookQueueSize = 1000
+
+var (
+	hookQueue = make(chan hookJob, hookQueueSize)
+
+	hookStatsMu sync.Mutex
+	hookStats   = make(map[string]*hookStat)
+)
+
+type hookStat struct {
+	Runs     uint64
+	Failures uint64
+}
+
+type hookJob struct {
+	Camera  string
+	Args    []string
+	Timeout time.Duration
+}
+
+// startHookWorkers launches the pool of goroutines running segment hooks
+func startHookWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go hookWorker()
+	}
+}
+
+func hookWorker() {
+	for job := range hookQueue {
+		runHook(job)
+	}
+}
+
+// hookArgs splits the command template into arguments and substitutes the placeholders.
+// Placeholders are substituted after splitting, so paths with spaces stay single arguments.
+func hookArgs(template string, c cameraConfig, path string, start time.Time, duration time.Duration) []string {
+	replacer := strings.NewReplacer(
+		"{camera}", c.Name,
+		"{path}", path,
+		"{start}", start.Format(time.RFC3339),
+		"{duration}", fmt.Sprintf("%.3f", duration.Seconds()),
+	)
+
+	args := make([]string, 0, 8)
+	for _, a := range regexp.MustCompile("\\s+").Split(template, -1) {
+		if a == "" {
+			continue
+		}
+		args = append(args, replacer.Replace(a))
+	}
+	return args
+}
+
+// runSegmentHooks queues onSegmentComplete commands of the camera for the complete segment
+func runSegmentHooks(c cameraConfig, path string) {
+	if len(c.OnSegmentComplete) == 0 {
+		return
+	}
+
+	start, err := parseSegmentStart(c, path)
+	if err != nil {
+		log.Errorf("Camera %s: can not run hooks for segment %s: %v", c.Name, path, err)
+		return
+	}
+	var duration time.Duration
+	if fi, err := os.Stat(path); err == nil && fi.ModTime().After(start) {
+		duration = fi.ModTime().Sub(start)
+	}
+
+	configMu.Lock()
+	timeout := time.Duration(config.HookTimeout) * time.Second
+	configMu.Unlock()
+
+	for _, template := range c.OnSegmentComplete {
+		args := hookArgs(template, c, path, start, duration)
+		if len(args) == 0 {
+			continue
+		}
+		select {
+		case hookQueue <- hookJob{Camera: c.Name, Args: args, Timeout: timeout}:
+		default:
+			log.Errorf("Camera %s: hook queue is full, dropping hook %s for segment %s", c.Name, args[0], path)
+			addHookStat(c.Name, false)
+		}
+	}
+}
+
+func runHook(job hookJob) {
+	ctx := context.Background()
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+
+	log.Debugf("Camera %s: running hook %v", job.Camera, job.Args)
+	out, err := exec.CommandContext(ctx, job.Args[0], job.Args[1:]...).CombinedOutput()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %v", job.Timeout)
+		}
+		log.Errorf("Camera %s: hook %v failed: %v: %s", job.Camera, job.Args, err, strings.TrimSpace(string(out)))
+		addHookStat(job.Camera, false)
+		return
+	}
+	addHookStat(job.Camera, true)
+}
+
+func addHookStat(camName string, success bool) {
+	hookStatsMu.Lock()
+	defer hookStatsMu.Unlock()
+
+	stat, ok := hookStats[camName]
+	if !ok {
+		stat = &hookStat{}
+		hookStats[camName] = stat
+	}
+	stat.Runs++
+	if !success {
+		stat.Failures++
+	}
+}
+
+func getHookStat(camName string) hookStat {
+	hookStatsMu.Lock()
+	defer hookStatsMu.Unlock()
+
+	stat, ok := hookStats[camName]
+	if !ok {
+		return hookStat{}
+	}
+	return *stat
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHookArgs(t *testing.T) {
+	c := cameraConfig{Name: "cam1"}
+	start := time.Date(2019, 10, 20, 14, 0, 0, 0, time.UTC)
+	args := hookArgs("/usr/local/bin/upload  --camera={camera} {path} {start} {duration}", c, "/storage/my cam/1.mkv", start, 599500*time.Millisecond)
+	assert.Equal(t, []string{"/usr/local/bin/upload", "--camera=cam1", "/storage/my cam/1.mkv", "2019-10-20T14:00:00Z", "599.500"}, args)
+}
+
+func TestRunHook(t *testing.T) {
+	runHook(hookJob{Camera: "hookcam", Args: []string{"true"}})
+	runHook(hookJob{Camera: "hookcam", Args: []string{"false"}})
+	runHook(hookJob{Camera: "hookcam", Args: []string{"sleep", "5"}, Timeout: 100 * time.Millisecond})
+
+	stat := getHookStat("hookcam")
+	assert.Equal(t, uint64(3), stat.Runs)
+	assert.Equal(t, uint64(2), stat.Failures)
+}
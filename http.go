@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/gorilla/mux"
 )
@@ -18,17 +19,44 @@ type jsonNameEntry struct {
 
 func newRouter() *mux.Router {
 	httpRouter := mux.NewRouter()
+	httpRouter.Use(authMiddleware)
 	httpRouter.HandleFunc("/cameras.json", zabbixAutodiscoveryCamerasListJSON)
+	httpRouter.HandleFunc("/metrics", metrics)
+	httpRouter.HandleFunc("/status.json", recorderStatusJSON)
+	httpRouter.HandleFunc("/camera/{name}/status.json", cameraStatusJSON)
+	httpRouter.HandleFunc("/camera/{name}/segments", cameraSegments)
+	httpRouter.HandleFunc("/camera/{name}/export", cameraExport)
+	httpRouter.HandleFunc("/camera/{name}/snapshot.jpg", cameraSnapshot)
+	httpRouter.HandleFunc("/camera/{name}/vod.m3u8", cameraVODPlaylist)
+	httpRouter.HandleFunc("/camera/{name}/vod/{path:.+}.ts", cameraVODSegment)
+	httpRouter.HandleFunc("/camera/{name}/live.m3u8", cameraLivePlaylist)
+	httpRouter.HandleFunc("/camera/{name}/{segment:live[0-9]+\\.ts}", cameraLiveSegment)
+	httpRouter.HandleFunc("/camera/{name}/log", cameraLog)
+	httpRouter.HandleFunc("/camera/{name}/errors/{category}", cameraErrors)
+	httpRouter.HandleFunc("/probe", probe).Methods("POST")
+	httpRouter.HandleFunc("/camera/{name}/stop", cameraStop).Methods("POST")
+	httpRouter.HandleFunc("/camera/{name}/start", cameraStart).Methods("POST")
+	httpRouter.HandleFunc("/camera/{name}/restart", cameraRestart).Methods("POST")
 	httpRouter.HandleFunc("/camera/{name}/frame", cameraFrame)
 	httpRouter.HandleFunc("/camera/{name}/fps", cameraFps)
 	httpRouter.HandleFunc("/camera/{name}/bitrate", cameraBitrate)
 	httpRouter.HandleFunc("/camera/{name}/outtime", cameraOutTime)
 	httpRouter.HandleFunc("/camera/{name}/dupframes", cameraDupFrames)
 	httpRouter.HandleFunc("/camera/{name}/dropframes", cameraDropFrames)
+	httpRouter.HandleFunc("/camera/{name}/restarts", cameraRestarts)
+	httpRouter.HandleFunc("/camera/{name}/stallrestarts", cameraStallRestarts)
+	httpRouter.HandleFunc("/camera/{name}/lastexitcode", cameraLastExitCode)
+	httpRouter.HandleFunc("/camera/{name}/lastexittime", cameraLastExitTime)
+	httpRouter.HandleFunc("/camera/{name}/crashloop", cameraCrashLoop)
+	httpRouter.HandleFunc("/camera/{name}/retentionfreed", cameraRetentionFreed)
+	httpRouter.HandleFunc("/camera/{name}/hookfailures", cameraHookFailures)
+	httpRouter.HandleFunc("/camera/{name}/freespace", cameraFreeSpace)
+	httpRouter.HandleFunc("/camera/{name}/lasteviction", cameraLastEviction)
 	return httpRouter
 }
 
-func zabbixAutodiscoveryCamerasListJSON(w http.ResponseWriter, r *http.Request) {
+// camerasListJSON returns Zabbix low-level discovery data of the cameras
+func camerasListJSON() ([]byte, error) {
 	reply := jsonCameraListReply{}
 	reply.Data = make([]jsonNameEntry, 0, 1024)
 
@@ -37,7 +65,11 @@ func zabbixAutodiscoveryCamerasListJSON(w http.ResponseWriter, r *http.Request)
 		reply.Data = append(reply.Data, cam)
 	}
 
-	json, err := json.MarshalIndent(reply, "", "\t")
+	return json.MarshalIndent(reply, "", "\t")
+}
+
+func zabbixAutodiscoveryCamerasListJSON(w http.ResponseWriter, r *http.Request) {
+	json, err := camerasListJSON()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -117,3 +149,132 @@ func cameraDropFrames(w http.ResponseWriter, r *http.Request) {
 	}
 	fmt.Fprintf(w, "%d", leech.Stats.DropFrames)
 }
+
+func cameraRestarts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "%d", atomic.LoadUint64(&leech.Restarts))
+}
+
+func cameraStallRestarts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "%d", atomic.LoadUint64(&leech.StallRestarts))
+}
+
+func cameraLastExitCode(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	exitCode, _, _ := leech.getRestartState()
+	fmt.Fprintf(w, "%d", exitCode)
+}
+
+func cameraLastExitTime(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	var ts int64
+	_, exitTime, _ := leech.getRestartState()
+	if !exitTime.IsZero() {
+		ts = exitTime.Unix()
+	}
+	fmt.Fprintf(w, "%d", ts)
+}
+
+func cameraCrashLoop(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	_, _, crashLoop := leech.getRestartState()
+	if crashLoop {
+		fmt.Fprint(w, "1")
+		return
+	}
+	fmt.Fprint(w, "0")
+}
+
+func cameraRetentionFreed(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	_, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "%d", getRetentionStat(camName).FreedBytes)
+}
+
+func cameraHookFailures(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	_, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "%d", getHookStat(camName).Failures)
+}
+
+func cameraFreeSpace(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	free, _, err := diskUsage(leech.Config.StoragePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "%d", free)
+}
+
+func cameraLastEviction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	var ts int64
+	stat := getStorageStat(leech.Config.StoragePath)
+	if !stat.LastEviction.IsZero() {
+		ts = stat.LastEviction.Unix()
+	}
+	fmt.Fprintf(w, "%d", ts)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How long the removed listener waits for the active requests to finish
+const httpShutdownTimeout = 5 * time.Second
+
+// Addresses starting with that prefix are Unix domain socket paths
+const unixSocketPrefix = "unix:"
+
+var (
+	httpHandler http.Handler
+
+	httpListenersMu sync.Mutex
+	httpListeners   = make(map[string]*http.Server)
+	// Certificates of the HTTPS listeners, by listener key
+	httpCertificates = make(map[string]*reloadableCertificate)
+)
+
+// listenAddresses is either a single address or a list of them in the config
+type listenAddresses []string
+
+func (a *listenAddresses) UnmarshalTOML(v interface{}) error {
+	switch value := v.(type) {
+	case string:
+		*a = listenAddresses{value}
+	case []interface{}:
+		addresses := make(listenAddresses, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return errors.New("listen address must be a string")
+			}
+			addresses = append(addresses, s)
+		}
+		*a = addresses
+	default:
+		return errors.New("listen address must be a string or an array of strings")
+	}
+	return nil
+}
+
+type httpListenerConfig struct {
+	Address      string
+	TLS          bool
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// key identifies the listener, the listener is rebound if any of its settings change
+func (l httpListenerConfig) key() string {
+	if !l.TLS {
+		return "http " + l.Address
+	}
+	return strings.Join([]string{"https", l.Address, l.CertFile, l.KeyFile, l.ClientCAFile}, " ")
+}
+
+func httpListenerConfigs(c tomlConfig) []httpListenerConfig {
+	listeners := make([]httpListenerConfig, 0, len(c.HTTPListenAddress)+len(c.HTTPSListenAddress))
+	for _, addr := range c.HTTPListenAddress {
+		listeners = append(listeners, httpListenerConfig{Address: addr})
+	}
+	for _, addr := range c.HTTPSListenAddress {
+		listeners = append(listeners, httpListenerConfig{Address: addr, TLS: true,
+			CertFile: c.TLSCertFile, KeyFile: c.TLSKeyFile, ClientCAFile: c.TLSClientCAFile})
+	}
+	return listeners
+}
+
+// listen opens TCP or Unix domain socket. Stale socket file left by the previous run is removed.
+func listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixSocketPrefix) {
+		return net.Listen("tcp", address)
+	}
+
+	path := strings.TrimPrefix(address, unixSocketPrefix)
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// applyHTTPListeners closes the listeners missing in the config and opens the new ones.
+// The listeners which haven't changed keep serving.
+func applyHTTPListeners(c tomlConfig) error {
+	httpListenersMu.Lock()
+	defer httpListenersMu.Unlock()
+
+	wanted := make(map[string]httpListenerConfig)
+	for _, l := range httpListenerConfigs(c) {
+		wanted[l.key()] = l
+	}
+
+	// The removed listeners are closed first, so that their addresses can be reused
+	for key, server := range httpListeners {
+		if _, ok := wanted[key]; ok {
+			continue
+		}
+		log.Infof("Closing HTTP listener %s", key)
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
+		cancel()
+		delete(httpListeners, key)
+		delete(httpCertificates, key)
+	}
+
+	errs := make([]string, 0)
+	for key, l := range wanted {
+		if _, ok := httpListeners[key]; ok {
+			// The certificate files may have been renewed in place
+			if cert, ok := httpCertificates[key]; ok {
+				if err := cert.load(l.CertFile, l.KeyFile); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can not reload TLS certificate, keeping the old one: %v", l.Address, err))
+				}
+			}
+			continue
+		}
+		server, cert, err := startHTTPListener(l)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", l.Address, err))
+			continue
+		}
+		httpListeners[key] = server
+		if cert != nil {
+			httpCertificates[key] = cert
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// startHTTPListener opens the listener and serves the API on it. The TLS certificate is loaded
+// before the address is bound, so that a bad certificate is reported instead of failing every handshake.
+func startHTTPListener(l httpListenerConfig) (*http.Server, *reloadableCertificate, error) {
+	server := &http.Server{Handler: httpHandler}
+	var cert *reloadableCertificate
+	if l.TLS {
+		tlsConfig, err := newTLSConfig(l.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cert = &reloadableCertificate{}
+		if err := cert.load(l.CertFile, l.KeyFile); err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.GetCertificate = cert.getCertificate
+		server.TLSConfig = tlsConfig
+	}
+
+	ln, err := listen(l.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Infof("Listening HTTP on %s (TLS: %v)", l.Address, l.TLS)
+
+	go func() {
+		var err error
+		if l.TLS {
+			err = server.ServeTLS(ln, "", "")
+		} else {
+			err = server.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Error serving HTTP on %s: %v", l.Address, err)
+		}
+	}()
+	return server, cert, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenAddresses(t *testing.T) {
+	var c tomlConfig
+	_, err := toml.Decode(`httpListenAddress = "127.0.0.1:8080"`, &c)
+	require.Nil(t, err)
+	assert.Equal(t, listenAddresses{"127.0.0.1:8080"}, c.HTTPListenAddress)
+
+	c = tomlConfig{}
+	_, err = toml.Decode(`httpListenAddress = ["127.0.0.1:8080", "unix:/run/cameraleech.sock"]`, &c)
+	require.Nil(t, err)
+	assert.Equal(t, listenAddresses{"127.0.0.1:8080", "unix:/run/cameraleech.sock"}, c.HTTPListenAddress)
+
+	_, err = toml.Decode(`httpListenAddress = 8080`, &c)
+	assert.NotNil(t, err)
+}
+
+func TestApplyHTTPListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, dir)
+
+	socketPath := filepath.Join(dir, "api.sock")
+	httpHandler = newRouter()
+	c := tomlConfig{HTTPListenAddress: listenAddresses{"127.0.0.1:0", unixSocketPrefix + socketPath}}
+	require.Nil(t, applyHTTPListeners(c))
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socketPath)
+		},
+	}}
+	resp, err := client.Get("http://localhost/cameras.json")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Reload without the Unix socket closes it, TCP listener keeps serving
+	tcpServer := httpListeners["http 127.0.0.1:0"]
+	c.HTTPListenAddress = listenAddresses{"127.0.0.1:0"}
+	require.Nil(t, applyHTTPListeners(c))
+	assert.Len(t, httpListeners, 1)
+	assert.True(t, tcpServer == httpListeners["http 127.0.0.1:0"])
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err))
+
+	require.Nil(t, applyHTTPListeners(tomlConfig{}))
+	assert.Len(t, httpListeners, 0)
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 with the common name
+func writeTestCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+func TestHTTPSCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	httpHandler = newRouter()
+
+	// A broken certificate fails the listener instead of every handshake
+	c := tomlConfig{HTTPSListenAddress: listenAddresses{"127.0.0.1:0"}, TLSCertFile: certFile, TLSKeyFile: keyFile}
+	assert.NotNil(t, applyHTTPListeners(c))
+	assert.Len(t, httpListeners, 0)
+
+	writeTestCertificate(t, certFile, keyFile, "first")
+	require.Nil(t, applyHTTPListeners(c))
+	defer applyHTTPListeners(tomlConfig{})
+
+	commonName := func() string {
+		cert, err := httpCertificates["https 127.0.0.1:0 "+certFile+" "+keyFile+" "].getCertificate(nil)
+		require.Nil(t, err)
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		require.Nil(t, err)
+		return parsed.Subject.CommonName
+	}
+	assert.Equal(t, "first", commonName())
+
+	// Renewed in place certificate is picked up on reload
+	writeTestCertificate(t, certFile, keyFile, "second")
+	require.Nil(t, applyHTTPListeners(c))
+	assert.Equal(t, "second", commonName())
+
+	// Unreadable renewal keeps serving the old certificate
+	require.Nil(t, ioutil.WriteFile(certFile, []byte("garbage"), 0644))
+	assert.NotNil(t, applyHTTPListeners(c))
+	assert.Equal(t, "second", commonName())
+}
+
+func TestLegacyTLSConfig(t *testing.T) {
+	require.Nil(t, readConfig("tests/goodconfig_legacytls.toml"))
+	assert.Len(t, config.HTTPListenAddress, 0)
+	assert.Equal(t, listenAddresses{"127.0.0.1:8443"}, config.HTTPSListenAddress)
+}
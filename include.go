@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// includedConfig is a file matched by the include patterns. Its defaults apply to the cameras of the file only
+type includedConfig struct {
+	Defaults cameraConfig
+	Cameras  map[string]cameraConfig
+}
+
+// includedFiles returns the files matched by the include patterns in order. Relative patterns are relative to the main config
+func includedFiles(c tomlConfig, mainPath string) ([]string, error) {
+	files := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, pattern := range c.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(mainPath), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Bad include pattern %s: %v", pattern, err)
+		}
+		for _, f := range matches {
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// includeConfigFiles adds the cameras of the included files to the config and their settings to the defined keys
+func includeConfigFiles(c *tomlConfig, mainPath string, defined map[string]bool) error {
+	files, err := includedFiles(*c, mainPath)
+	if err != nil {
+		return err
+	}
+
+	if c.Cameras == nil {
+		c.Cameras = make(map[string]cameraConfig)
+	}
+	sources := make(map[string]string, len(c.Cameras))
+	for name := range c.Cameras {
+		sources[name] = mainPath
+	}
+
+	for _, f := range files {
+		var inc includedConfig
+		md, err := toml.DecodeFile(f, &inc)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f, err)
+		}
+		fileDefined := definedKeys(md)
+		for name, camConfig := range inc.Cameras {
+			if other, ok := sources[name]; ok {
+				return fmt.Errorf("Camera %s is defined both in %s and %s", name, other, f)
+			}
+			sources[name] = f
+			inheritDefaults(&camConfig, inc.Defaults, name, fileDefined)
+			for k := range fileDefined {
+				if strings.HasPrefix(k, "cameras."+name+".") {
+					defined[k] = true
+				}
+			}
+			c.Cameras[name] = camConfig
+		}
+	}
+	return nil
+}
+
+// inheritDefaults sets the camera settings missing in the file to the values of the file defaults.
+// The inherited settings are marked as defined for the camera
+func inheritDefaults(c *cameraConfig, defaults cameraConfig, camName string, defined map[string]bool) {
+	v := reflect.ValueOf(c).Elem()
+	d := reflect.ValueOf(defaults)
+	for i := 0; i < v.NumField(); i++ {
+		setting := strings.ToLower(v.Type().Field(i).Name)
+		if defined[cameraKey(camName, setting)] || !defined["defaults."+setting] {
+			continue
+		}
+		v.Field(i).Set(d.Field(i))
+		defined[cameraKey(camName, setting)] = true
+	}
+}
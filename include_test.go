@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncludeConfig(t *testing.T) {
+	require.Nil(t, readConfig("tests/goodconfig_include.toml"))
+	require.Len(t, config.Cameras, 4)
+	assert.Equal(t, 600, config.Cameras["cam1"].SegmentTime)
+	assert.Equal(t, 300, config.Cameras["cam2"].SegmentTime)
+	assert.Equal(t, 60, config.Cameras["cam3"].SegmentTime)
+	assert.Equal(t, 600, config.Cameras["cam4"].SegmentTime)
+	assert.Equal(t, "/tmp/cameraleech_test", config.Cameras["cam4"].StoragePath)
+
+	err := readConfig("tests/badconfig_include_duplicate.toml")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Camera cam2 is defined both in tests/badconfig_include_duplicate.toml and tests/include.d/site1.toml")
+}
+
+func TestIncludeReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cameraleech-include-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mainConfig := "include = [\"conf.d/*.toml\"]\n[defaults]\nstoragePath = \"/tmp/cameraleech_test\"\n"
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "main.toml"), []byte(mainConfig), 0644))
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "conf.d"), 0755))
+
+	require.Nil(t, readConfig(filepath.Join(dir, "main.toml")))
+	assert.Empty(t, config.Cameras)
+
+	camConfig := "[cameras.cam1]\nurl = \"rtsp://127.0.0.1/stream\"\n"
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "cam1.toml"), []byte(camConfig), 0644))
+	require.Nil(t, readConfig(filepath.Join(dir, "main.toml")))
+	assert.Contains(t, config.Cameras, "cam1")
+
+	require.Nil(t, os.Remove(filepath.Join(dir, "conf.d", "cam1.toml")))
+	require.Nil(t, readConfig(filepath.Join(dir, "main.toml")))
+	assert.Empty(t, config.Cameras)
+}
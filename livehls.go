@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+)
+
+// Live HLS playlist keeps that many segments of that length (in seconds)
+const (
+	liveHLSTime     = 2
+	liveHLSListSize = 5
+)
+
+// liveHLSDir returns folder where ffmpeg writes the rolling live HLS playlist of the camera
+func liveHLSDir(c cameraConfig) string {
+	return filepath.Join(cameraDir(c), "live")
+}
+
+// prepareLiveHLSDir removes stale playlist and segments left from the previous ffmpeg run
+func prepareLiveHLSDir(c cameraConfig) error {
+	if err := os.RemoveAll(liveHLSDir(c)); err != nil {
+		return err
+	}
+	return os.MkdirAll(liveHLSDir(c), 0755)
+}
+
+// liveHLSOutputArgs returns the additional ffmpeg output writing the rolling live HLS playlist.
+// Only video is restreamed since cameras often use audio codecs not supported by MPEG-TS.
+func liveHLSOutputArgs(c cameraConfig) []string {
+	dir := liveHLSDir(c)
+	return []string{"-map", "0:v:0", "-codec", "copy", "-f", "hls",
+		"-hls_time", fmt.Sprint(liveHLSTime), "-hls_list_size", fmt.Sprint(liveHLSListSize),
+		"-hls_flags", "delete_segments", "-hls_allow_cache", "0",
+		"-hls_segment_filename", filepath.Join(dir, "live%d.ts"), filepath.Join(dir, "live.m3u8")}
+}
+
+func cameraLivePlaylist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	if !leech.Config.LiveHLS {
+		http.Error(w, fmt.Sprintf("Live HLS is disabled for camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	path := filepath.Join(liveHLSDir(leech.Config), "live.m3u8")
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "Live playlist is not available yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, path)
+}
+
+func cameraLiveSegment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	if !leech.Config.LiveHLS {
+		http.Error(w, fmt.Sprintf("Live HLS is disabled for camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	// segment name is validated by the router pattern, so it can't escape the folder
+	path := filepath.Join(liveHLSDir(leech.Config), vars["segment"])
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "Segment has expired", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveHLSOptOut(t *testing.T) {
+	require.Nil(t, readConfig("tests/goodconfig_livehls.toml"))
+	assert.True(t, config.Cameras["cam1"].LiveHLS)
+	assert.Equal(t, 10, config.Cameras["cam1"].SnapshotInterval)
+	assert.False(t, config.Cameras["cam2"].LiveHLS)
+	assert.Equal(t, 0, config.Cameras["cam2"].SnapshotInterval)
+}
+
+func TestCameraLiveHLS(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	c := cameraConfig{Name: "cam1", StoragePath: storagePath, FilenameTemplate: defaultFilenameTemplate, LiveHLS: true}
+	c2 := c
+	c2.Name = "cam2"
+	c2.LiveHLS = false
+	leeches = map[string]*leech{"cam1": newLeech(c), "cam2": newLeech(c2)}
+	defer func() { leeches = make(map[string]*leech) }()
+
+	router := newRouter()
+	// Stale files of the previous run are removed
+	require.Nil(t, os.MkdirAll(liveHLSDir(c), 0755))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(liveHLSDir(c), "live.m3u8"), []byte("stale"), 0644))
+	require.Nil(t, prepareLiveHLSDir(c))
+
+	code, _ := testGet(t, router, "/camera/cam1/live.m3u8")
+	assert.Equal(t, 503, code)
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(liveHLSDir(c), "live.m3u8"), []byte("#EXTM3U\nlive7.ts\n"), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(liveHLSDir(c), "live7.ts"), []byte("ts"), 0644))
+	code, body := testGet(t, router, "/camera/cam1/live.m3u8")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "#EXTM3U\nlive7.ts\n", body)
+
+	code, body = testGet(t, router, "/camera/cam1/live7.ts")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "ts", body)
+
+	code, _ = testGet(t, router, "/camera/cam1/live6.ts")
+	assert.Equal(t, 404, code)
+	code, _ = testGet(t, router, "/camera/cam2/live.m3u8")
+	assert.Equal(t, 404, code)
+	code, _ = testGet(t, router, "/camera/cam3/live.m3u8")
+	assert.Equal(t, 404, code)
+}
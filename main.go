@@ -1,12 +1,14 @@
 package main
 
 import (
-	"net/http"
+	"flag"
+	"math/rand"
 	"os"
 	"os/signal"
 	"runtime"
 	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -16,16 +18,23 @@ var (
 	commit            string // value is provided in compilation phase (see Makefile)
 	builtat           string // value is provided in compilation phase (see Makefile)
 	programIsStopping bool   // if the main program is stopping, it should be set to true
-	httpServer        *http.Server
 )
 
 func main() {
 	parseFlags()
+	rand.Seed(time.Now().UnixNano())
+
+	if flag.NArg() > 0 {
+		os.Exit(runSubcommand(flag.Args()))
+	}
 
 	log.Infof("Cameraleech commit %s, built at %s, %s started", commit, builtat, runtime.Version())
 
 	// subscribing on SIGINT, SIGTERM - graceful shutdown of ffmpegs
 	// Also SIGHUP for reload
+	// The handler must exist before SIGHUP may rebind the listeners
+	httpHandler = newRouter()
+
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	go signalWatcher(signalChan)
@@ -37,22 +46,31 @@ func main() {
 	// print hints
 	hints()
 
+	// Pool size can't be changed on reload
+	startHookWorkers(config.HookWorkers)
+
+	// MQTT broker settings can't be changed on reload either
+	if config.MQTT.Broker != "" {
+		if err := startMQTT(config.MQTT); err != nil {
+			log.Errorf("Couldn't connect to MQTT broker: %v", err)
+		}
+	}
+
 	err := launchLeeches()
 	if err != nil {
 		log.Errorf("Couldn't launch camera leeches: %v", err)
 	}
 	log.Info("Camera leeches are launched")
 
-	httpRouter := newRouter()
-	http.Handle("/", httpRouter)
-
-	httpServer := &http.Server{Addr: config.httpListenAddress, Handler: httpRouter}
+	go retentionWatcher()
+	go watermarkWatcher()
+	go segmentStatsWatcher()
+	go mqttWatcher()
+	go zabbixSenderWatcher()
 
-	go func() {
-		if err := httpServer.ListenAndServe(); err != nil {
-			log.Fatalf("Error listening http stats server: %v", err)
-		}
-	}()
+	if err := applyHTTPListeners(config); err != nil {
+		log.Fatalf("Error listening http stats server: %v", err)
+	}
 
 	cond = &sync.Cond{L: &sync.Mutex{}}
 	cond.L.Lock()
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"strconv"
@@ -28,6 +29,16 @@ func deleteDownloadedData(t *testing.T, path string) {
 	assert.Nil(t, err)
 }
 
+// testGet makes GET request to the router and returns the response status code and body
+func testGet(t *testing.T, router http.Handler, url string) (int, string) {
+	req := httptest.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	body, err := ioutil.ReadAll(w.Result().Body)
+	require.Nil(t, err)
+	return w.Result().StatusCode, string(body)
+}
+
 func stopLeeches(t *testing.T) {
 	for k, l := range leeches {
 		err := l.Stop()
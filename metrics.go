@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How often camera folders are scanned for the segment statistics
+const segmentStatsInterval = 30 * time.Second
+
+var (
+	segmentStatsMu sync.Mutex
+	segmentStats   = make(map[string]*segmentStat)
+)
+
+type segmentStat struct {
+	BytesOnDisk     uint64
+	SegmentsOnDisk  uint64
+	SegmentsWritten uint64 // counted by renameSegment, not by the scan
+}
+
+// segmentStatsWatcher periodically scans camera folders so that /metrics and segment queries don't hit the disk on every request
+func segmentStatsWatcher() {
+	for {
+		if programIsStopping {
+			return
+		}
+
+		configMu.Lock()
+		cameras := make([]cameraConfig, 0, len(config.Cameras))
+		for _, c := range config.Cameras {
+			cameras = append(cameras, c)
+		}
+		configMu.Unlock()
+
+		for _, c := range cameras {
+			if err := updateSegmentStat(c); err != nil {
+				log.Errorf("Camera %s: failed to collect segment statistics: %v", c.Name, err)
+			}
+		}
+		time.Sleep(segmentStatsInterval)
+	}
+}
+
+func updateSegmentStat(c cameraConfig) error {
+	segments, err := listCameraSegments(c)
+	if err != nil {
+		return err
+	}
+	updateSegmentCatalog(c.Name, segments)
+
+	segmentStatsMu.Lock()
+	defer segmentStatsMu.Unlock()
+
+	stat := segmentStatLocked(c.Name)
+	var bytes uint64
+	for _, s := range segments {
+		bytes += uint64(s.Size)
+	}
+	stat.BytesOnDisk = bytes
+	stat.SegmentsOnDisk = uint64(len(segments))
+	return nil
+}
+
+// addSegmentWritten counts the segment ffmpeg has completed
+func addSegmentWritten(camName string) {
+	segmentStatsMu.Lock()
+	defer segmentStatsMu.Unlock()
+	segmentStatLocked(camName).SegmentsWritten++
+}
+
+// segmentStatLocked returns the camera statistics, creating them if needed. Must be called with segmentStatsMu held.
+func segmentStatLocked(camName string) *segmentStat {
+	stat, ok := segmentStats[camName]
+	if !ok {
+		stat = &segmentStat{}
+		segmentStats[camName] = stat
+	}
+	return stat
+}
+
+func getSegmentStat(camName string) segmentStat {
+	segmentStatsMu.Lock()
+	defer segmentStatsMu.Unlock()
+
+	stat, ok := segmentStats[camName]
+	if !ok {
+		return segmentStat{}
+	}
+	return *stat
+}
+
+type cameraMetric struct {
+	name  string
+	help  string
+	typ   string
+	value func(l *leech) string
+}
+
+var cameraMetrics = []cameraMetric{
+	{"cameraleech_frame", "Last frame number reported by ffmpeg", "gauge",
+		func(l *leech) string { return fmt.Sprint(l.Stats.Frame) }},
+	{"cameraleech_fps", "Average frame rate", "gauge",
+		func(l *leech) string { return fmt.Sprint(l.Stats.Fps) }},
+	{"cameraleech_bitrate_kbits", "Average bitrate in kbit/s, -1 if not reported by ffmpeg", "gauge",
+		func(l *leech) string { return fmt.Sprint(l.Stats.Bitrate) }},
+	{"cameraleech_out_time_seconds", "Amount of video time written by ffmpeg", "gauge",
+		func(l *leech) string { return fmt.Sprint(float64(l.Stats.OutTime) / 1000000) }},
+	{"cameraleech_dup_frames", "Amount of duplicate frames", "gauge",
+		func(l *leech) string { return fmt.Sprint(l.Stats.DupFrames) }},
+	{"cameraleech_drop_frames", "Amount of dropped frames", "gauge",
+		func(l *leech) string { return fmt.Sprint(l.Stats.DropFrames) }},
+	{"cameraleech_ffmpeg_restarts_total", "Amount of ffmpeg restarts", "counter",
+		func(l *leech) string { return fmt.Sprint(atomic.LoadUint64(&l.Restarts)) }},
+	{"cameraleech_ffmpeg_stall_restarts_total", "Amount of ffmpeg restarts caused by stalled stream", "counter",
+		func(l *leech) string { return fmt.Sprint(atomic.LoadUint64(&l.StallRestarts)) }},
+	{"cameraleech_ffmpeg_last_exit_code", "Exit code of the last finished ffmpeg process", "gauge",
+		func(l *leech) string { code, _, _ := l.getRestartState(); return fmt.Sprint(code) }},
+	{"cameraleech_crash_loop", "1 if the camera is in the crash loop state", "gauge",
+		func(l *leech) string {
+			if _, _, crashLoop := l.getRestartState(); crashLoop {
+				return "1"
+			}
+			return "0"
+		}},
+	{"cameraleech_segments_written_total", "Amount of segment files written since the program start", "counter",
+		func(l *leech) string { return fmt.Sprint(getSegmentStat(l.Config.Name).SegmentsWritten) }},
+	{"cameraleech_segments_on_disk", "Amount of segment files stored on disk", "gauge",
+		func(l *leech) string { return fmt.Sprint(getSegmentStat(l.Config.Name).SegmentsOnDisk) }},
+	{"cameraleech_storage_bytes", "Size of the camera segments stored on disk", "gauge",
+		func(l *leech) string { return fmt.Sprint(getSegmentStat(l.Config.Name).BytesOnDisk) }},
+	{"cameraleech_retention_freed_bytes_total", "Amount of bytes freed by the retention policy", "counter",
+		func(l *leech) string { return fmt.Sprint(getRetentionStat(l.Config.Name).FreedBytes) }},
+	{"cameraleech_hook_runs_total", "Amount of onSegmentComplete hooks run", "counter",
+		func(l *leech) string { return fmt.Sprint(getHookStat(l.Config.Name).Runs) }},
+	{"cameraleech_hook_failures_total", "Amount of failed or dropped onSegmentComplete hooks", "counter",
+		func(l *leech) string { return fmt.Sprint(getHookStat(l.Config.Name).Failures) }},
+}
+
+// escapeLabelValue escapes the label value according to the Prometheus text format
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(s)
+}
+
+func writeMetricHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func metrics(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(leeches))
+	for k := range leeches {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	for _, m := range cameraMetrics {
+		writeMetricHeader(w, m.name, m.help, m.typ)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{camera=\"%s\"} %s\n", m.name, escapeLabelValue(name), m.value(leeches[name]))
+		}
+	}
+
+	writeMetricHeader(w, "cameraleech_ffmpeg_errors_total", "Amount of ffmpeg stderr lines by category", "counter")
+	for _, name := range names {
+		counts := leeches[name].stderrLog.errorCounts()
+		categories := make([]string, 0, len(counts))
+		for c := range counts {
+			categories = append(categories, c)
+		}
+		sort.Strings(categories)
+		for _, c := range categories {
+			fmt.Fprintf(w, "cameraleech_ffmpeg_errors_total{camera=\"%s\",category=\"%s\"} %d\n", escapeLabelValue(name), c, counts[c])
+		}
+	}
+
+	storagePaths := make(map[string]bool)
+	for _, name := range names {
+		storagePaths[leeches[name].Config.StoragePath] = true
+	}
+	paths := make([]string, 0, len(storagePaths))
+	for p := range storagePaths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	writeMetricHeader(w, "cameraleech_storage_free_bytes", "Free space of the filesystem containing the storage path", "gauge")
+	for _, p := range paths {
+		free, _, err := diskUsage(p)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "cameraleech_storage_free_bytes{path=\"%s\"} %d\n", escapeLabelValue(p), free)
+	}
+
+	writeMetricHeader(w, "cameraleech_storage_evicted_bytes_total", "Amount of bytes evicted due to low free space", "counter")
+	for _, p := range paths {
+		fmt.Fprintf(w, "cameraleech_storage_evicted_bytes_total{path=\"%s\"} %d\n", escapeLabelValue(p), getStorageStat(p).EvictedBytes)
+	}
+
+	writeMetricHeader(w, "cameraleech_cameras", "Amount of configured cameras", "gauge")
+	fmt.Fprintf(w, "cameraleech_cameras %d\n", len(names))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	l := newLeech(cameraConfig{Name: "cam\"1", StoragePath: "/"})
+	l.Stats = progressMessage{Frame: 42, Fps: 25, Bitrate: -1, OutTime: 1500000}
+	l.Restarts = 3
+	leeches = map[string]*leech{"cam\"1": l}
+	defer func() { leeches = nil }()
+
+	router := newRouter()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, string(body), "# TYPE cameraleech_frame gauge\n")
+	assert.Contains(t, string(body), "cameraleech_frame{camera=\"cam\\\"1\"} 42\n")
+	assert.Contains(t, string(body), "cameraleech_bitrate_kbits{camera=\"cam\\\"1\"} -1\n")
+	assert.Contains(t, string(body), "cameraleech_out_time_seconds{camera=\"cam\\\"1\"} 1.5\n")
+	assert.Contains(t, string(body), "cameraleech_ffmpeg_restarts_total{camera=\"cam\\\"1\"} 3\n")
+	assert.Contains(t, string(body), "cameraleech_cameras 1\n")
+}
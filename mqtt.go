@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// How often camera states are published to MQTT. Statistics are published as soon as they are computed.
+const mqttPublishInterval = 30 * time.Second
+
+// How long the publisher waits for the broker on connect and shutdown
+const mqttTimeout = 10 * time.Second
+
+const (
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+)
+
+// mqttClient is nil when MQTT publishing isn't configured
+var mqttClient mqtt.Client
+
+type mqttConfig struct {
+	Broker      string // tcp://host:1883, ssl://host:8883
+	ClientID    string
+	Username    string
+	Password    string
+	TopicPrefix string
+}
+
+// mqttStatusTopic is the recorder status topic, it is set to "offline" by the last will
+func mqttStatusTopic(c mqttConfig) string {
+	return c.TopicPrefix + "/status"
+}
+
+func mqttCameraTopic(c mqttConfig, camName, topic string) string {
+	return fmt.Sprintf("%s/%s/%s", c.TopicPrefix, camName, topic)
+}
+
+// startMQTT connects to the broker. mqttWatcher reconnects if the first attempt has failed or the connection is lost.
+// The client's own auto reconnect is disabled, so that it doesn't race the watcher and open duplicate sessions.
+func startMQTT(c mqttConfig) error {
+	opts := mqtt.NewClientOptions().
+		AddBroker(c.Broker).
+		SetClientID(c.ClientID).
+		SetUsername(c.Username).
+		SetPassword(c.Password).
+		SetConnectTimeout(mqttTimeout).
+		SetAutoReconnect(false).
+		SetWill(mqttStatusTopic(c), mqttOffline, 1, true)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Infof("Connected to MQTT broker %s", c.Broker)
+		client.Publish(mqttStatusTopic(c), 1, true, mqttOnline)
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Warnf("Lost connection to MQTT broker %s: %v", c.Broker, err)
+	})
+
+	client := mqtt.NewClient(opts)
+	mqttClient = client
+	token := client.Connect()
+	if !waitMQTTToken(token) {
+		return fmt.Errorf("timed out connecting to MQTT broker %s", c.Broker)
+	}
+	return token.Error()
+}
+
+// stopMQTT marks the recorder and all the cameras offline and disconnects from the broker
+func stopMQTT() {
+	if mqttClient == nil {
+		return
+	}
+	c := getMQTTConfig()
+	for name := range leeches {
+		mqttClient.Publish(mqttCameraTopic(c, name, "state"), 1, true, mqttOffline).WaitTimeout(mqttTimeout)
+	}
+	mqttClient.Publish(mqttStatusTopic(c), 1, true, mqttOffline).WaitTimeout(mqttTimeout)
+	mqttClient.Disconnect(250)
+}
+
+// mqttWatcher periodically publishes states of all the cameras
+func mqttWatcher() {
+	for {
+		if programIsStopping {
+			return
+		}
+		reconnectMQTT()
+		for _, l := range leeches {
+			state, _ := cameraState(l)
+			l.publishState(state)
+		}
+		time.Sleep(mqttPublishInterval)
+	}
+}
+
+// waitMQTTToken waits for the token up to mqttTimeout. Token's WaitTimeout isn't used since it holds
+// the token lock while waiting, so a failed connection is only reported when the timeout expires
+func waitMQTTToken(token mqtt.Token) bool {
+	done := make(chan struct{})
+	go func() {
+		token.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(mqttTimeout):
+		return false
+	}
+}
+
+// reconnectMQTT connects to the broker if the connection has been lost or has never been established
+func reconnectMQTT() {
+	if mqttClient == nil || mqttClient.IsConnected() {
+		return
+	}
+	c := getMQTTConfig()
+	token := mqttClient.Connect()
+	if !waitMQTTToken(token) {
+		log.Warnf("Timed out reconnecting to MQTT broker %s", c.Broker)
+		return
+	}
+	if err := token.Error(); err != nil {
+		log.Warnf("Couldn't reconnect to MQTT broker %s: %v", c.Broker, err)
+	}
+}
+
+func getMQTTConfig() mqttConfig {
+	configMu.Lock()
+	defer configMu.Unlock()
+	return config.MQTT
+}
+
+// publishState publishes retained camera state: online if ffmpeg is running, offline otherwise
+func (l *leech) publishState(state string) {
+	if mqttClient == nil {
+		return
+	}
+	payload := mqttOffline
+	if state == stateRunning {
+		payload = mqttOnline
+	}
+	mqttClient.Publish(mqttCameraTopic(getMQTTConfig(), l.Config.Name, "state"), 1, true, payload)
+}
+
+// publishStats publishes camera statistics computed by sendProgressReport
+func (l *leech) publishStats() {
+	if mqttClient == nil {
+		return
+	}
+	payload, err := json.Marshal(l.Stats)
+	if err != nil {
+		log.Errorf("Camera %s: failed to encode statistics: %v", l.Config.Name, err)
+		return
+	}
+	mqttClient.Publish(mqttCameraTopic(getMQTTConfig(), l.Config.Name, "stats"), 0, false, payload)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runTestBroker serves a single MQTT client, acknowledging everything it sends
+func runTestBroker(ln net.Listener, connects chan *packets.ConnectPacket, publishes chan *packets.PublishPacket) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			connects <- p
+			packets.NewControlPacket(packets.Connack).Write(conn)
+		case *packets.PublishPacket:
+			publishes <- p
+			if p.Qos == 1 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				ack.Write(conn)
+			}
+		case *packets.PingreqPacket:
+			packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func receivePublish(t *testing.T, publishes chan *packets.PublishPacket) *packets.PublishPacket {
+	select {
+	case p := <-publishes:
+		return p
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "broker hasn't received the message")
+	}
+	return nil
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	connects := make(chan *packets.ConnectPacket, 1)
+	publishes := make(chan *packets.PublishPacket, 10)
+	go runTestBroker(ln, connects, publishes)
+
+	c := mqttConfig{Broker: "tcp://" + ln.Addr().String(), ClientID: "cameraleech-test", TopicPrefix: "cameraleech/testhost"}
+	configMu.Lock()
+	oldMQTT := config.MQTT
+	config.MQTT = c
+	configMu.Unlock()
+	defer func() {
+		configMu.Lock()
+		config.MQTT = oldMQTT
+		configMu.Unlock()
+		mqttClient.Disconnect(0)
+		mqttClient = nil
+	}()
+
+	err = startMQTT(c)
+	require.Nil(t, err)
+
+	// The last will marks the recorder offline if it dies
+	connect := <-connects
+	assert.True(t, connect.WillFlag)
+	assert.True(t, connect.WillRetain)
+	assert.Equal(t, "cameraleech/testhost/status", connect.WillTopic)
+	assert.Equal(t, mqttOffline, string(connect.WillMessage))
+
+	p := receivePublish(t, publishes)
+	assert.Equal(t, "cameraleech/testhost/status", p.TopicName)
+	assert.Equal(t, mqttOnline, string(p.Payload))
+	assert.True(t, p.Retain)
+
+	l := newLeech(cameraConfig{Name: "cam1"})
+	l.publishState(stateStopped)
+	p = receivePublish(t, publishes)
+	assert.Equal(t, "cameraleech/testhost/cam1/state", p.TopicName)
+	assert.Equal(t, mqttOffline, string(p.Payload))
+	assert.True(t, p.Retain)
+
+	// Events of the output grabber must not wait for controlMu, start holds it while waiting for the grabber
+	l.controlMu.Lock()
+	notified := make(chan struct{})
+	go func() {
+		l.notify(eventRecovered)
+		close(notified)
+	}()
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Error("notify is blocked by controlMu")
+	}
+	l.controlMu.Unlock()
+	p = receivePublish(t, publishes)
+	assert.Equal(t, "cameraleech/testhost/cam1/state", p.TopicName)
+	assert.Equal(t, mqttOnline, string(p.Payload))
+
+	l.Stats.Frame = 42
+	l.publishStats()
+	p = receivePublish(t, publishes)
+	assert.Equal(t, "cameraleech/testhost/cam1/stats", p.TopicName)
+	var stats progressMessage
+	require.Nil(t, json.Unmarshal(p.Payload, &stats))
+	assert.Equal(t, uint64(42), stats.Frame)
+}
+
+func TestMQTTReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	connects := make(chan *packets.ConnectPacket, 2)
+	publishes := make(chan *packets.PublishPacket, 10)
+	go func() {
+		// The broker drops the first connections (MQTT 3.1.1 and 3.1 fallback), then serves normally
+		for i := 0; i < 2; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			packets.ReadPacket(conn)
+			conn.Close()
+		}
+		runTestBroker(ln, connects, publishes)
+	}()
+
+	c := mqttConfig{Broker: "tcp://" + ln.Addr().String(), ClientID: "cameraleech-test", TopicPrefix: "cameraleech/testhost"}
+	configMu.Lock()
+	oldMQTT := config.MQTT
+	config.MQTT = c
+	configMu.Unlock()
+	defer func() {
+		configMu.Lock()
+		config.MQTT = oldMQTT
+		configMu.Unlock()
+		mqttClient.Disconnect(0)
+		mqttClient = nil
+	}()
+
+	require.NotNil(t, startMQTT(c))
+	assert.False(t, mqttClient.IsConnected())
+
+	reconnectMQTT()
+	assert.True(t, mqttClient.IsConnected())
+	assert.Len(t, connects, 1)
+
+	// Connected client isn't connected again
+	reconnectMQTT()
+	assert.Len(t, connects, 1)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Camera event types sent to the webhooks
+const (
+	eventExited    = "exited"     // ffmpeg has exited and is going to be restarted
+	eventCrashLoop = "crash_loop" // camera has entered crash loop state
+	eventStalled   = "stalled"    // stream has stalled and ffmpeg is being restarted
+	eventRecovered = "recovered"  // stream is flowing again after ffmpeg restart
+)
+
+// Camera state after the event. The events are sent by the output grabber, which start waits for
+// with controlMu held, so the state is not looked up with cameraState
+var eventStates = map[string]string{
+	eventExited:    stateRestarting,
+	eventCrashLoop: stateRestarting,
+	eventStalled:   stateRestarting,
+	eventRecovered: stateRunning,
+}
+
+// Amount of events waiting to be sent to a single webhook. When the queue is full, new events are dropped.
+const webhookQueueSize = 1000
+
+// Amount of attempts to deliver the event to a webhook
+const webhookAttempts = 4
+
+var (
+	webhookQueuesMu sync.Mutex
+	webhookQueues   = make(map[string]chan cameraEvent)
+
+	webhookClient     = &http.Client{Timeout: 10 * time.Second}
+	webhookRetryDelay = 1 * time.Second // doubled after every failed attempt
+)
+
+type cameraEvent struct {
+	Camera    string    `json:"camera"`
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	LastError string    `json:"lastError"` // the last line ffmpeg has written to stderr
+	Restarts  uint64    `json:"restarts"`
+}
+
+// notify sends the camera event to all configured webhooks without blocking the caller.
+// Camera state is published to MQTT as well.
+func (l *leech) notify(event string) {
+	l.publishState(eventStates[event])
+
+	sendEvent(cameraEvent{
+		Camera:    l.Config.Name,
+		Event:     event,
+		Timestamp: time.Now(),
+		LastError: l.lastLogLine(),
+		Restarts:  atomic.LoadUint64(&l.Restarts),
+	})
+}
+
+func sendEvent(ev cameraEvent) {
+	// The queues are closed on reload, so they must not be taken outside of the lock
+	webhookQueuesMu.Lock()
+	defer webhookQueuesMu.Unlock()
+
+	configMu.Lock()
+	urls := config.Webhooks
+	configMu.Unlock()
+
+	for _, url := range urls {
+		select {
+		case webhookQueue(url) <- ev:
+		default:
+			log.Errorf("Camera %s: webhook %s queue is full, dropping %s event", ev.Camera, url, ev.Event)
+		}
+	}
+}
+
+// webhookQueue returns queue of the webhook, starting its sender if needed.
+// Every webhook has its own sender, so that a slow receiver doesn't delay the others.
+// Must be called with webhookQueuesMu held.
+func webhookQueue(url string) chan cameraEvent {
+	queue, ok := webhookQueues[url]
+	if !ok {
+		queue = make(chan cameraEvent, webhookQueueSize)
+		webhookQueues[url] = queue
+		go webhookSender(url, queue)
+	}
+	return queue
+}
+
+// stopRemovedWebhooks closes queues of the webhooks which are not configured anymore.
+// Their senders exit once the queued events are sent.
+func stopRemovedWebhooks(urls []string) {
+	webhookQueuesMu.Lock()
+	defer webhookQueuesMu.Unlock()
+
+	configured := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		configured[url] = true
+	}
+	for url, queue := range webhookQueues {
+		if !configured[url] {
+			close(queue)
+			delete(webhookQueues, url)
+		}
+	}
+}
+
+func webhookSender(url string, queue chan cameraEvent) {
+	for ev := range queue {
+		if err := postWebhook(url, ev); err != nil {
+			log.Errorf("Camera %s: failed to send %s event to webhook %s: %v", ev.Camera, ev.Event, url, err)
+		}
+	}
+}
+
+// postWebhook POSTs the event as JSON, retrying with exponential delay until it is accepted with 2xx status
+func postWebhook(url string, ev cameraEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = postWebhookOnce(url, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		log.Warnf("Camera %s: webhook %s attempt %d failed: %v", ev.Camera, url, attempt, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func postWebhookOnce(url string, body []byte) error {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setTestWebhooks(urls []string) func() {
+	configMu.Lock()
+	oldWebhooks := config.Webhooks
+	config.Webhooks = urls
+	configMu.Unlock()
+	return func() {
+		configMu.Lock()
+		config.Webhooks = oldWebhooks
+		configMu.Unlock()
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	oldRetryDelay := webhookRetryDelay
+	webhookRetryDelay = 10 * time.Millisecond
+	defer func() { webhookRetryDelay = oldRetryDelay }()
+
+	var requests int32
+	received := make(chan cameraEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails, so the event has to be retried
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		var ev cameraEvent
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&ev))
+		received <- ev
+	}))
+	defer server.Close()
+	defer setTestWebhooks([]string{server.URL})()
+	defer stopRemovedWebhooks(nil)
+
+	l := newLeech(cameraConfig{Name: "hookcam"})
+	l.sendLog("Connection refused")
+	l.notify(eventExited)
+
+	select {
+	case ev := <-received:
+		assert.Equal(t, "hookcam", ev.Camera)
+		assert.Equal(t, eventExited, ev.Event)
+		assert.Equal(t, "Connection refused", ev.LastError)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "webhook hasn't received the event")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestStopRemovedWebhooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	defer setTestWebhooks([]string{server.URL, server.URL + "/other"})()
+
+	sendEvent(cameraEvent{Camera: "hookcam", Event: eventExited})
+	webhookQueuesMu.Lock()
+	removed := webhookQueues[server.URL+"/other"]
+	require.Len(t, webhookQueues, 2)
+	webhookQueuesMu.Unlock()
+
+	// The webhook is removed from the config on reload
+	stopRemovedWebhooks([]string{server.URL})
+	webhookQueuesMu.Lock()
+	assert.Len(t, webhookQueues, 1)
+	webhookQueuesMu.Unlock()
+
+	// The sender drains the queue and exits
+	assert.Eventually(t, func() bool {
+		select {
+		case _, ok := <-removed:
+			return !ok
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stopRemovedWebhooks(nil)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultProbeTimeout = 30 * time.Second
+	// Longer probes would hold an ffmpeg process per request for too long
+	maxProbeTimeout = 2 * time.Minute
+)
+
+var (
+	probeVideoRegexp      = regexp.MustCompile(`Stream #\d+:\d+.*: Video: (\w+)`)
+	probeResolutionRegexp = regexp.MustCompile(`, (\d+)x(\d+)`)
+	probeFpsRegexp        = regexp.MustCompile(`, ([\d.]+k?) (fps|tbr)`)
+	probeAudioRegexp      = regexp.MustCompile(`Stream #\d+:\d+.*: Audio: (\w+)`)
+)
+
+type probeResult struct {
+	URL              string  `json:"url"`
+	OK               bool    `json:"ok"`
+	Error            string  `json:"error,omitempty"`
+	ErrorCategory    string  `json:"errorCategory,omitempty"` // see logCategories
+	VideoCodec       string  `json:"videoCodec"`
+	Width            int     `json:"width"`
+	Height           int     `json:"height"`
+	Fps              float64 `json:"fps"`
+	Audio            bool    `json:"audio"`
+	AudioCodec       string  `json:"audioCodec"`
+	TimeToFirstFrame float64 `json:"timeToFirstFrame"` // seconds
+}
+
+// probeConfig returns config of the camera with the URL as it would be recorded with the default settings
+func probeConfig(rawurl string) (cameraConfig, error) {
+	c := config.Defaults
+	c.Name = "probe"
+	c.URL = rawurl
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return c, fmt.Errorf("can not parse URL: %v", maskCredentials(err.Error()))
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return c, fmt.Errorf("URL %s must have scheme and host", maskCredentials(rawurl))
+	}
+
+	// Credentials in the URL take precedence over the default ones
+	if u.User != nil {
+		c.Username = ""
+		c.Password = ""
+	} else if c.Username != "" && c.Password == "" && c.PasswordFile != "" {
+		password, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return c, fmt.Errorf("can not read password file: %v", err)
+		}
+		c.Password = strings.TrimSpace(string(password))
+	}
+	return c, nil
+}
+
+// parseProbeOutput fills the stream info from ffmpeg stderr. The input streams are printed first, so the first match wins
+func parseProbeOutput(lines []string, r *probeResult) {
+	for _, line := range lines {
+		if m := probeVideoRegexp.FindStringSubmatch(line); m != nil && r.VideoCodec == "" {
+			r.VideoCodec = m[1]
+			if m := probeResolutionRegexp.FindStringSubmatch(line); m != nil {
+				r.Width, _ = strconv.Atoi(m[1])
+				r.Height, _ = strconv.Atoi(m[2])
+			}
+			if m := probeFpsRegexp.FindStringSubmatch(line); m != nil {
+				fps := m[1]
+				multiplier := 1.0
+				if strings.HasSuffix(fps, "k") {
+					fps = strings.TrimSuffix(fps, "k")
+					multiplier = 1000
+				}
+				f, _ := strconv.ParseFloat(fps, 64)
+				r.Fps = f * multiplier
+			}
+		}
+		if m := probeAudioRegexp.FindStringSubmatch(line); m != nil && !r.Audio {
+			r.Audio = true
+			r.AudioCodec = m[1]
+		}
+	}
+}
+
+// probeCamera connects to the camera exactly the way the recorder does and waits for the first video frame.
+// The probe is aborted when ctx is done, e.g. the HTTP client went away.
+func probeCamera(ctx context.Context, c cameraConfig, timeout time.Duration) probeResult {
+	r := probeResult{URL: maskCameraCredentials(c, c.URL)}
+
+	inputArgs, err := ffmpegInputArgs(c)
+	if err != nil {
+		r.Error = maskCameraCredentials(c, err.Error())
+		r.ErrorCategory = logCategoryOther
+		return r
+	}
+	ffmpegArgs := []string{"-hide_banner", "-nostdin", "-loglevel", "info"}
+	ffmpegArgs = append(ffmpegArgs, inputArgs...)
+	ffmpegArgs = append(ffmpegArgs, "-an", "-sn", "-dn", "-codec", "copy", "-frames:v", "1", "-f", "null", "-")
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Debugf("Camera %s: probing %s", c.Name, r.URL)
+	startedAt := time.Now()
+	out, err := exec.CommandContext(ctx, c.FfmpegPath, ffmpegArgs...).CombinedOutput()
+	elapsed := time.Since(startedAt)
+
+	lines := strings.Split(strings.TrimSpace(maskCameraCredentials(c, string(out))), "\n")
+	parseProbeOutput(lines, &r)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		r.Error = fmt.Sprintf("no video frame received in %v", timeout)
+		r.ErrorCategory = "timeout"
+		return r
+	}
+	if ctx.Err() == context.Canceled {
+		r.Error = "probe canceled"
+		r.ErrorCategory = logCategoryOther
+		return r
+	}
+	if err != nil {
+		r.Error = fmt.Sprintf("ffmpeg failed: %v: %s", err, lines[len(lines)-1])
+		r.ErrorCategory = logCategoryOther
+		for _, line := range lines {
+			if category := classifyLogLine(line); category != logCategoryOther {
+				r.Error = line
+				r.ErrorCategory = category
+				break
+			}
+		}
+		return r
+	}
+	if r.VideoCodec == "" {
+		r.Error = "no video stream found"
+		r.ErrorCategory = "codec"
+		return r
+	}
+
+	r.OK = true
+	r.TimeToFirstFrame = elapsed.Seconds()
+	return r
+}
+
+func parseProbeTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultProbeTimeout, nil
+	}
+	seconds, err := strconv.Atoi(s)
+	if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxProbeTimeout {
+		return 0, fmt.Errorf("timeout must be a number of seconds from 1 to %d", int(maxProbeTimeout.Seconds()))
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func probe(w http.ResponseWriter, r *http.Request) {
+	timeout, err := parseProbeTimeout(r.FormValue("timeout"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	configMu.Lock()
+	c, err := probeConfig(r.FormValue("url"))
+	configMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result := probeCamera(r.Context(), c, timeout)
+	if r.Context().Err() != nil {
+		log.Debugf("Camera %s: probe of %s canceled by the client", c.Name, result.URL)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// probeCommand implements "cameraleech probe" subcommand
+func probeCommand(args []string) int {
+	flags := flag.NewFlagSet("probe", flag.ExitOnError)
+	timeout := flags.Int("timeout", int(defaultProbeTimeout.Seconds()), "Seconds to wait for the first video frame")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *timeout <= 0 {
+		fmt.Fprintln(os.Stderr, "Usage: cameraleech [-config path] probe [-timeout seconds] url")
+		return 2
+	}
+
+	if err := readConfig(configPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Can not read config: %s\n", err)
+		return 1
+	}
+
+	c, err := probeConfig(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	r := probeCamera(context.Background(), c, time.Duration(*timeout)*time.Second)
+	fmt.Printf("URL: %s\n", r.URL)
+	if !r.OK {
+		fmt.Printf("Failed (%s): %s\n", r.ErrorCategory, r.Error)
+		return 1
+	}
+	fmt.Printf("Video: %s %dx%d %.2f fps\n", r.VideoCodec, r.Width, r.Height, r.Fps)
+	if r.Audio {
+		fmt.Printf("Audio: %s\n", r.AudioCodec)
+	} else {
+		fmt.Println("Audio: none")
+	}
+	fmt.Printf("Time to first frame: %.3fs\n", r.TimeToFirstFrame)
+	return 0
+}
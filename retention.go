@@ -0,0 +1,243 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How often the retention policy is applied to the cameras
+const retentionCheckInterval = 5 * time.Minute
+
+var (
+	retentionStatsMu sync.Mutex
+	retentionStats   = make(map[string]*retentionStat)
+)
+
+type retentionStat struct {
+	DeletedFiles uint64
+	FreedBytes   uint64
+}
+
+type segmentFile struct {
+	Path    string
+	Start   time.Time
+	Size    int64
+	ModTime time.Time
+	Partial bool // ffmpeg is still writing the segment
+}
+
+// retentionWatcher periodically removes old segments of every camera
+// which has retentionDays or maxStorageBytes set
+func retentionWatcher() {
+	for {
+		if programIsStopping {
+			return
+		}
+		applyRetention(time.Now())
+		time.Sleep(retentionCheckInterval)
+	}
+}
+
+func applyRetention(now time.Time) {
+	configMu.Lock()
+	cameras := make([]cameraConfig, 0, len(config.Cameras))
+	for _, c := range config.Cameras {
+		cameras = append(cameras, c)
+	}
+	configMu.Unlock()
+
+	for _, c := range cameras {
+		if c.RetentionDays == 0 && c.MaxStorageBytes == 0 {
+			continue
+		}
+		if err := pruneCamera(c, now); err != nil {
+			log.Errorf("Camera %s: error applying retention policy: %v", c.Name, err)
+		}
+	}
+}
+
+// listCameraSegments returns segment files of the camera ordered from the oldest to the newest
+func listCameraSegments(c cameraConfig) ([]segmentFile, error) {
+	re := segmentPathRegexp(c)
+	segments := make([]segmentFile, 0, 256)
+
+	err := filepath.Walk(segmentRoot(c), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(c.StoragePath, path)
+		if err != nil {
+			return nil
+		}
+		start, err := parseTemplateTime(re, filepath.ToSlash(rel))
+		if err != nil {
+			return nil
+		}
+		segments = append(segments, segmentFile{
+			Path:    path,
+			Start:   start,
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			Partial: strings.HasSuffix(path, partialSuffix),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].Start.Before(segments[j].Start)
+	})
+	return segments, nil
+}
+
+// pruneCamera deletes the oldest segments of the camera until both retention limits are met.
+// The newest segment is never deleted since ffmpeg may be still writing it.
+func pruneCamera(c cameraConfig, now time.Time) error {
+	segments, err := listCameraSegments(c)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, s := range segments {
+		total += s.Size
+	}
+
+	var deletedFiles, freedBytes uint64
+	cutoff := now.AddDate(0, 0, -c.RetentionDays)
+	for i := 0; i < len(segments)-1; i++ {
+		s := segments[i]
+		if s.Partial {
+			continue
+		}
+		expired := c.RetentionDays > 0 && s.ModTime.Before(cutoff)
+		oversized := c.MaxStorageBytes > 0 && total > c.MaxStorageBytes
+		if !expired && !oversized {
+			break
+		}
+
+		if err := os.Remove(s.Path); err != nil {
+			log.Errorf("Camera %s: failed to remove segment %s: %v", c.Name, s.Path, err)
+			continue
+		}
+		log.Infof("Camera %s: retention removed segment %s (%d bytes)", c.Name, s.Path, s.Size)
+		total -= s.Size
+		deletedFiles++
+		freedBytes += uint64(s.Size)
+	}
+
+	addRetentionStat(c.Name, deletedFiles, freedBytes)
+
+	return removeEmptySegmentFolders(c, now)
+}
+
+// removeEmptySegmentFolders deletes empty folders created according to the filename template.
+// Today's and next day's folders are kept as ffmpeg expects them to exist.
+func removeEmptySegmentFolders(c cameraConfig, now time.Time) error {
+	root := segmentRoot(c)
+	dirTemplate := strings.Split(segmentDirTemplate(c), "/")
+	keep := map[string]bool{
+		segmentDir(c, now):                  true,
+		segmentDir(c, now.AddDate(0, 0, 1)): true,
+	}
+
+	dirs := make([]string, 0, 64)
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() && path != root {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Walking in reverse order removes nested folders before their parents
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path := dirs[i]
+		rel, err := filepath.Rel(c.StoragePath, path)
+		if err != nil {
+			continue
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) > len(dirTemplate) {
+			continue
+		}
+		prefixTemplate := strings.Join(dirTemplate[:len(parts)], "/")
+		if !templateRegexp(prefixTemplate).MatchString(filepath.ToSlash(rel)) {
+			continue
+		}
+		if keep[path] || keepsSegmentDir(keep, path) {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		log.Infof("Camera %s: retention removed empty folder %s", c.Name, path)
+	}
+	return nil
+}
+
+// keepsSegmentDir returns true if the folder is a parent of one of the kept folders
+func keepsSegmentDir(keep map[string]bool, dir string) bool {
+	for k := range keep {
+		if strings.HasPrefix(k, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func addRetentionStat(camName string, deletedFiles, freedBytes uint64) {
+	retentionStatsMu.Lock()
+	defer retentionStatsMu.Unlock()
+
+	stat, ok := retentionStats[camName]
+	if !ok {
+		stat = &retentionStat{}
+		retentionStats[camName] = stat
+	}
+	stat.DeletedFiles += deletedFiles
+	stat.FreedBytes += freedBytes
+}
+
+func getRetentionStat(camName string) retentionStat {
+	retentionStatsMu.Lock()
+	defer retentionStatsMu.Unlock()
+
+	stat, ok := retentionStats[camName]
+	if !ok {
+		return retentionStat{}
+	}
+	return *stat
+}
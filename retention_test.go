@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestSegment(t *testing.T, path string, size int, modTime time.Time) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	require.Nil(t, err)
+	err = ioutil.WriteFile(path, make([]byte, size), 0644)
+	require.Nil(t, err)
+	err = os.Chtimes(path, modTime, modTime)
+	require.Nil(t, err)
+}
+
+func TestPruneCamera(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	now := time.Date(2019, 10, 20, 12, 0, 0, 0, time.Local)
+	camDir := filepath.Join(storagePath, "cam1")
+	createTestSegment(t, filepath.Join(camDir, "2019-10-10", "2019-10-10_10-00-00.mkv"), 100, now.AddDate(0, 0, -10))
+	createTestSegment(t, filepath.Join(camDir, "2019-10-18", "2019-10-18_10-00-00.mkv"), 100, now.AddDate(0, 0, -2))
+	createTestSegment(t, filepath.Join(camDir, "2019-10-19", "2019-10-19_10-00-00.mkv"), 100, now.AddDate(0, 0, -1))
+	createTestSegment(t, filepath.Join(camDir, "2019-10-20", "2019-10-20_10-00-00.mkv"), 100, now)
+	err = os.MkdirAll(filepath.Join(camDir, "2019-10-21"), 0755)
+	require.Nil(t, err)
+
+	// Age-based retention removes the old segment and its folder
+	c := cameraConfig{Name: "cam1", StoragePath: storagePath, RetentionDays: 5, Container: "mkv", FilenameTemplate: defaultFilenameTemplate}
+	err = pruneCamera(c, now)
+	require.Nil(t, err)
+
+	_, err = os.Stat(filepath.Join(camDir, "2019-10-10"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, uint64(100), getRetentionStat("cam1").FreedBytes)
+
+	// Size-based retention removes the oldest segments but never the newest one
+	c = cameraConfig{Name: "cam1", StoragePath: storagePath, MaxStorageBytes: 50, Container: "mkv", FilenameTemplate: defaultFilenameTemplate}
+	err = pruneCamera(c, now)
+	require.Nil(t, err)
+
+	segments, err := listCameraSegments(c)
+	require.Nil(t, err)
+	require.Len(t, segments, 1)
+	assert.Equal(t, filepath.Join(camDir, "2019-10-20", "2019-10-20_10-00-00.mkv"), segments[0].Path)
+	assert.Equal(t, uint64(300), getRetentionStat("cam1").FreedBytes)
+
+	// Next day folder is kept even though it is empty
+	_, err = os.Stat(filepath.Join(camDir, "2019-10-21"))
+	assert.Nil(t, err)
+}
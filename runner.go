@@ -1,12 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-cmd/cmd"
@@ -20,65 +22,143 @@ var (
 type leech struct {
 	Config cameraConfig
 
-	stopEverything   bool // If set to true, everything will stop
-	stopEverythingMu sync.Mutex
-	watcherStarted   bool
-	stopWatcher      chan int
+	controlMu      sync.Mutex // serializes Start, Stop and restarts made by the watcher
+	watcherStarted bool
+	watcherStop    chan struct{} // closed by Stop to terminate the watcher
+	watcherDone    chan struct{} // closed by the watcher when it exits
+	grabberDone    chan struct{} // closed by the output grabber when it exits
 
 	progMsgsCounter     int
 	progMsgsStringsPool []string
 	progMsgsPool        []progressMessage
 	Stats               progressMessage
+	Restarts            uint64 // amount of ffmpeg restarts, accessed atomically
+	StallRestarts       uint64 // amount of ffmpeg restarts caused by stalled stream, accessed atomically
+
+	progressMu          sync.Mutex // guards the progress tracked by the stall watchdog
+	lastProgressAt      time.Time
+	lastProgressFrame   uint64
+	lastProgressOutTime uint64
 
 	command *cmd.Cmd
 	status  <-chan cmd.Status
+
+	stateMu        sync.Mutex
+	startedAt      time.Time
+	lastExitCode   int
+	lastExitTime   time.Time
+	restartAttempt int
+	restartTimes   []time.Time
+	crashLoop      bool
+	stallKill      bool // ffmpeg has been stopped by the stall watchdog
+	recovering     bool // ffmpeg has been restarted and hasn't received any frames yet
+
+	stderrLog ffmpegLog
 }
 
 type progressMessage struct {
-	Frame      uint64
-	Fps        float32
-	Bitrate    int
-	OutTime    uint64 // miliseconds
-	DupFrames  int
-	DropFrames int
+	Frame      uint64  `json:"frame"`
+	Fps        float32 `json:"fps"`
+	Bitrate    int     `json:"bitrate"`
+	OutTime    uint64  `json:"outTime"` // miliseconds
+	DupFrames  int     `json:"dupFrames"`
+	DropFrames int     `json:"dropFrames"`
 }
 
+// How long Stop waits for ffmpeg to finish writing the segment and exit
+const ffmpegStopTimeout = 10 * time.Second
+
+var errLeechRunning = errors.New("Camera is already running")
+
 func newLeech(c cameraConfig) *leech {
 	l := new(leech)
 	l.Config = cameraConfig{}
 	l.Config = c
 	l.Stats = progressMessage{}
-	l.stopWatcher = make(chan int, 1)
 	l.progMsgsStringsPool = make([]string, 0, 128)
 	l.progMsgsPool = make([]progressMessage, 0, 128)
 	return l
 }
 
+// Start launches ffmpeg along with the watcher restarting it
 func (l *leech) Start() error {
+	l.controlMu.Lock()
+	defer l.controlMu.Unlock()
+
+	if l.watcherStarted {
+		return errLeechRunning
+	}
+
+	if err := l.start(); err != nil {
+		return err
+	}
+
+	log.Debugf("Camera %s: starting watcher", l.Config.Name)
+	l.watcherStarted = true
+	l.watcherStop = make(chan struct{})
+	l.watcherDone = make(chan struct{})
+	go l.watch(l.watcherStop, l.watcherDone)
+
+	// Starting routine creating folders for the next day
+	go l.createNextDaySubfoldersRoutine(l.watcherStop)
+
+	return nil
+}
+
+// ffmpegInputArgs returns ffmpeg arguments opening the camera stream: input options and the input URL
+func ffmpegInputArgs(c cameraConfig) ([]string, error) {
+	args := make([]string, 0, 10)
+	for _, i := range regexp.MustCompile("\\s+").Split(c.InputOptions, -1) {
+		if i == "" {
+			continue
+		}
+		args = append(args, i)
+	}
+
+	inputURL, err := ffmpegInputURL(c)
+	if err != nil {
+		return nil, err
+	}
+	return append(args, "-i", inputURL), nil
+}
+
+// start assembles ffmpeg command and launches it. Must be called with controlMu held.
+func (l *leech) start() error {
 	var ffmpegArgs []string
-	var err error
-	l.setStopEverythingValue(false)
 
-	inputArgs := regexp.MustCompile("\\s+").Split(l.Config.InputOptions, -1)
-	filePath := fmt.Sprintf("%s/%s/%%Y-%%m-%%d/%%Y-%%m-%%d_%%H-%%M-%%S.mkv", l.Config.StoragePath, l.Config.Name)
+	filePath := segmentOutputPath(l.Config)
+	container := containers[l.Config.Container]
 	ffmpegArgs = make([]string, 0, 30)
 
 	log.Debugf("Stream %s: Assembling ffmpeg command", l.Config.Name)
 	ffmpegArgs = append(ffmpegArgs, "-hide_banner", "-nostdin", "-nostats", "-progress", "pipe:1",
 		"-loglevel", l.Config.FfmpegLogLevel)
 
-	if len(inputArgs) > 0 {
-		for _, i := range inputArgs {
-			if i == "" {
-				continue
-			}
-			ffmpegArgs = append(ffmpegArgs, i)
-		}
+	if l.Config.SnapshotInterval > 0 {
+		ffmpegArgs = append(ffmpegArgs, snapshotInputArgs()...)
 	}
 
-	ffmpegArgs = append(ffmpegArgs, "-i", l.Config.URL, "-codec", "copy",
+	inputArgs, err := ffmpegInputArgs(l.Config)
+	if err != nil {
+		return err
+	}
+	ffmpegArgs = append(ffmpegArgs, inputArgs...)
+	ffmpegArgs = append(ffmpegArgs, "-codec", "copy",
 		"-f", "segment", "-segment_time", fmt.Sprint(l.Config.SegmentTime), "-reset_timestamps", "1",
-		"-segment_atclocktime", "1", "-strftime", "1", filePath)
+		"-segment_atclocktime", "1", "-strftime", "1", "-segment_format", container.SegmentFormat)
+	if container.FormatOptions != "" {
+		ffmpegArgs = append(ffmpegArgs, "-segment_format_options", container.FormatOptions)
+	}
+	// ffmpeg reports every complete segment to the list, so it can be renamed to the final name
+	ffmpegArgs = append(ffmpegArgs, "-segment_list", "pipe:1", "-segment_list_type", "flat", filePath)
+
+	if l.Config.SnapshotInterval > 0 {
+		ffmpegArgs = append(ffmpegArgs, snapshotOutputArgs(l.Config)...)
+	}
+
+	if l.Config.LiveHLS {
+		ffmpegArgs = append(ffmpegArgs, liveHLSOutputArgs(l.Config)...)
+	}
 
 	log.Debug("Creating necessary subfolders (if needed)")
 	if err := l.createSubFolders(); err != nil {
@@ -86,113 +166,209 @@ func (l *leech) Start() error {
 		return err
 	}
 
+	if l.Config.LiveHLS {
+		if err := prepareLiveHLSDir(l.Config); err != nil {
+			log.Errorf("Error creating live HLS folder for camera %s: %v", l.Config.Name, err)
+			return err
+		}
+	}
+
+	// Previous output grabber must finish before the new one starts tracking progress
+	if l.grabberDone != nil {
+		select {
+		case <-l.grabberDone:
+		case <-time.After(ffmpegStopTimeout):
+			log.Warnf("Camera %s: previous output grabber is still running", l.Config.Name)
+		}
+	}
+
+	// Segments left partial by the previous ffmpeg run won't be written anymore
+	finalizePartialSegments(l.Config)
+
 	l.command = cmd.NewCmdOptions(cmd.Options{Streaming: true}, l.Config.FfmpegPath, ffmpegArgs...)
+	l.resetProgress()
 	l.status = l.command.Start()
+	l.stateMu.Lock()
+	l.startedAt = time.Now()
+	l.stateMu.Unlock()
 	log.Infof("Camera %s: ffmpeg leech started", l.Config.Name)
 
-	if !l.watcherStarted {
-		// Starting crash watcher if the program has been started
-		log.Debugf("Camera %s: starting watcher", l.Config.Name)
-		go func() {
-			l.watcherStarted = true
-			for {
-				log.Debugf("Camera %s: waiting watcher channel to send event", l.Config.Name)
-				<-l.status
-				if l.getStopEverythingValue() {
-					l.watcherStarted = false
-					return
-				}
+	// Starting a goroutine which will be grabbing strings from stdout and stderr chans
+	log.Debugf("Camera %s: Starting output grabber", l.Config.Name)
+	l.grabberDone = make(chan struct{})
+	go l.grabOutput(l.command, l.grabberDone)
 
-				l.stopWatcher <- 666
+	return nil
+}
 
-				if err != nil {
-					log.Errorf("Camera %s: command was finished with error: %v", l.Config.Name, err)
-				}
+// watch restarts ffmpeg whenever it exits until the leech is stopped
+func (l *leech) watch(stop, done chan struct{}) {
+	defer close(done)
+	for {
+		l.controlMu.Lock()
+		statusChan := l.status
+		l.controlMu.Unlock()
+
+		log.Debugf("Camera %s: waiting watcher channel to send event", l.Config.Name)
+		var status cmd.Status
+		select {
+		case status = <-statusChan:
+		case <-stop:
+			return
+		}
 
-				time.Sleep(1 * time.Second)
+		select {
+		case <-stop:
+			return
+		default:
+		}
 
-				log.Infof("Camera %s: restarting ffmpeg command with args %v", l.Config.Name, ffmpegArgs)
-				l.Start()
-			}
-		}()
-	}
+		if status.Error != nil {
+			log.Errorf("Camera %s: command was finished with error: %v", l.Config.Name, status.Error)
+		} else {
+			log.Errorf("Camera %s: command was finished with exit code %d", l.Config.Name, status.Exit)
+		}
+
+		_, _, wasCrashLoop := l.getRestartState()
+		delay := l.recordExit(status)
+		l.notify(eventExited)
+		if _, _, crashLoop := l.getRestartState(); crashLoop && !wasCrashLoop {
+			l.notify(eventCrashLoop)
+		}
 
-	// Starting routine creating folders for the next day
-	go func() {
 		for {
-			if l.getStopEverythingValue() {
+			log.Infof("Camera %s: restarting ffmpeg in %v", l.Config.Name, delay)
+			select {
+			case <-time.After(delay):
+			case <-stop:
 				return
 			}
 
-			t := time.Now()
-			hour := t.Hour()
-			minute := t.Minute()
-			if hour == 23 && minute > 50 && minute < 56 {
-				if err := l.createNextDaySubfolders(); err != nil {
-					log.Errorf("Error creating next-day subfolder for camera %s: %v", l.Config.Name, err)
-				}
+			l.controlMu.Lock()
+			select {
+			case <-stop:
+				l.controlMu.Unlock()
+				return
+			default:
 			}
-			time.Sleep(295 * time.Second)
+			atomic.AddUint64(&l.Restarts, 1)
+			err := l.start()
+			if err == nil {
+				log.Infof("Camera %s: restarted ffmpeg command with args %s", l.Config.Name, maskCameraCredentials(l.Config, strings.Join(l.command.Args, " ")))
+			}
+			l.controlMu.Unlock()
+
+			if err == nil {
+				break
+			}
+			l.stateMu.Lock()
+			delay = l.nextRestartDelay()
+			l.stateMu.Unlock()
 		}
-	}()
+	}
+}
 
-	// Starting a goroutine which will be grabbing strings from stdout and stderr chans
-	log.Debugf("Camera %s: Starting output grabber", l.Config.Name)
-	go func() {
-		for {
-			if l.getStopEverythingValue() {
-				return
+// grabOutput reads ffmpeg stdout and stderr until the command is finished
+func (l *leech) grabOutput(command *cmd.Cmd, done chan struct{}) {
+	defer close(done)
+	stallTicker := time.NewTicker(stallCheckInterval)
+	defer stallTicker.Stop()
+	for {
+		select {
+		case stdout := <-command.Stdout:
+			// Segment list entries are written to stdout along with the progress
+			if strings.HasSuffix(stdout, partialSuffix) {
+				l.finalizeSegment(stdout)
+				continue
 			}
+			l.handleProgressMessage(stdout)
+		case stderr := <-command.Stderr:
+			l.sendLog(stderr)
+		case <-stallTicker.C:
+			l.checkStall(command)
+		case <-command.Done():
+			log.Infof("Camera %s: program output grabber routine destroyed", l.Config.Name)
+			return
+		}
+	}
+}
 
-			select {
-			case stdout := <-l.command.Stdout:
-				l.handleProgressMessage(stdout)
-			case stderr := <-l.command.Stderr:
-				l.sendLog(stderr)
-			case <-l.stopWatcher:
-				log.Infof("Camera %s: program output grabber routine destroyed", l.Config.Name)
-				return
+func (l *leech) createNextDaySubfoldersRoutine(stop chan struct{}) {
+	for {
+		t := time.Now()
+		hour := t.Hour()
+		minute := t.Minute()
+		if hour == 23 && minute > 50 && minute < 56 {
+			if err := l.createNextDaySubfolders(); err != nil {
+				log.Errorf("Error creating next-day subfolder for camera %s: %v", l.Config.Name, err)
 			}
 		}
-	}()
 
-	return nil
+		select {
+		case <-time.After(295 * time.Second):
+		case <-stop:
+			return
+		}
+	}
 }
 
-func (l *leech) setStopEverythingValue(val bool) {
-	l.stopEverythingMu.Lock()
-	defer l.stopEverythingMu.Unlock()
-	l.stopEverything = val
+// waitCommandStarted waits for the process to be spawned, since go-cmd ignores Stop called before that
+func waitCommandStarted(command *cmd.Cmd) {
+	deadline := time.Now().Add(ffmpegStopTimeout)
+	for command.Status().StartTs == 0 && time.Now().Before(deadline) {
+		select {
+		case <-command.Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
 }
 
-func (l *leech) getStopEverythingValue() bool {
-	l.stopEverythingMu.Lock()
-	defer l.stopEverythingMu.Unlock()
-	return l.stopEverything
+// isRunning returns true if the leech has been started and not stopped yet
+func (l *leech) isRunning() bool {
+	l.controlMu.Lock()
+	defer l.controlMu.Unlock()
+	return l.watcherStarted
 }
 
 func (l *leech) createSubFolders() error {
-	t := time.Now()
-	dateString := fmt.Sprintf("%d-%02d-%02d", t.Year(), t.Month(), t.Day())
-	path := fmt.Sprintf("%s/%s/%s", l.Config.StoragePath, l.Config.Name, dateString)
-	return os.MkdirAll(path, 0755)
+	return os.MkdirAll(segmentDir(l.Config, time.Now()), 0755)
 }
 
 func (l *leech) createNextDaySubfolders() error {
-	t := time.Now().AddDate(0, 0, 1)
-	dateString := fmt.Sprintf("%d-%02d-%02d", t.Year(), t.Month(), t.Day())
-	path := fmt.Sprintf("%s/%s/%s", l.Config.StoragePath, l.Config.Name, dateString)
-	return os.MkdirAll(path, 0755)
+	return os.MkdirAll(segmentDir(l.Config, time.Now().AddDate(0, 0, 1)), 0755)
 }
 
+// Stop terminates ffmpeg and waits for the watcher to exit
 func (l *leech) Stop() error {
 	log.Infof("Camera %s: stopping", l.Config.Name)
-	l.setStopEverythingValue(true)
-	err := l.command.Stop()
+
+	l.controlMu.Lock()
+	if !l.watcherStarted {
+		l.controlMu.Unlock()
+		return nil
+	}
+	l.watcherStarted = false
+	close(l.watcherStop)
+	done := l.watcherDone
+	command := l.command
+	waitCommandStarted(command)
+	err := command.Stop()
+	l.controlMu.Unlock()
+
+	<-done
 	if err != nil {
 		log.Errorf("Camera %s: error during command stop: %v", l.Config.Name, err)
 		return err
 	}
+
+	select {
+	case <-command.Done():
+	case <-time.After(ffmpegStopTimeout):
+		log.Warnf("Camera %s: ffmpeg didn't exit within %v", l.Config.Name, ffmpegStopTimeout)
+		return nil
+	}
+	finalizePartialSegments(l.Config)
 	return nil
 }
 
@@ -265,6 +441,7 @@ func (l *leech) handleProgressMessage(str string) {
 		}
 
 		l.progMsgsStringsPool = nil
+		l.trackProgress(msg)
 
 		l.progMsgsPool = append(l.progMsgsPool, msg)
 		l.progMsgsCounter++
@@ -299,8 +476,5 @@ func (l *leech) sendProgressReport() {
 
 	l.Stats.Fps = avgFPSTemp / float32(len(l.progMsgsPool))
 	l.Stats.Bitrate = biteateAvgTemp / len(l.progMsgsPool)
-}
-
-func (l *leech) sendLog(str string) {
-	log.Infof("%s ffmpeg output: %s", l.Config.Name, str)
+	l.publishStats()
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Time format used in the names of exported files
+const segmentNameLayout = "2006-01-02_15-04-05"
+
+var (
+	segmentCatalogMu sync.Mutex
+	segmentCatalog   = make(map[string][]segmentInfo)
+)
+
+type segmentInfo struct {
+	Path  string
+	Start time.Time
+	End   time.Time // time of the last write to the segment
+	Size  int64
+}
+
+type jsonSegment struct {
+	Path     string    `json:"path"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"` // seconds
+	Offset   float64   `json:"offset"`   // seconds from the segment start to the beginning of requested range
+	Length   float64   `json:"length"`   // seconds of the requested range covered by the segment
+	Size     int64     `json:"size"`
+}
+
+type jsonSegmentsReply struct {
+	Camera   string        `json:"camera"`
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	Segments []jsonSegment `json:"segments"`
+}
+
+// updateSegmentCatalog replaces the catalog of camera segments. Segments must be ordered from the oldest to the newest.
+func updateSegmentCatalog(camName string, segments []segmentFile) {
+	infos := make([]segmentInfo, 0, len(segments))
+	for _, s := range segments {
+		end := s.ModTime
+		if end.Before(s.Start) {
+			end = s.Start
+		}
+		infos = append(infos, segmentInfo{Path: s.Path, Start: s.Start, End: end, Size: s.Size})
+	}
+
+	segmentCatalogMu.Lock()
+	defer segmentCatalogMu.Unlock()
+	segmentCatalog[camName] = infos
+}
+
+// renameCatalogSegment updates path of the finalized segment, so that it can be found before the next catalog update
+func renameCatalogSegment(camName, oldPath, newPath string) {
+	segmentCatalogMu.Lock()
+	defer segmentCatalogMu.Unlock()
+
+	infos := segmentCatalog[camName]
+	for i := len(infos) - 1; i >= 0; i-- {
+		if infos[i].Path == oldPath {
+			infos[i].Path = newPath
+			return
+		}
+	}
+}
+
+// findSegments returns segments of the camera overlapping the [from, to) time range
+func findSegments(c cameraConfig, from, to time.Time) ([]jsonSegment, error) {
+	segmentCatalogMu.Lock()
+	infos, ok := segmentCatalog[c.Name]
+	segmentCatalogMu.Unlock()
+
+	if !ok {
+		segments, err := listCameraSegments(c)
+		if err != nil {
+			return nil, err
+		}
+		updateSegmentCatalog(c.Name, segments)
+
+		segmentCatalogMu.Lock()
+		infos = segmentCatalog[c.Name]
+		segmentCatalogMu.Unlock()
+	}
+
+	result := make([]jsonSegment, 0, 16)
+	for i, s := range infos {
+		// The newest segment is likely still being written, so its end is refreshed
+		if i == len(infos)-1 {
+			if fi, err := os.Stat(s.Path); err == nil && fi.ModTime().After(s.End) {
+				s.End = fi.ModTime()
+				s.Size = fi.Size()
+			}
+		}
+
+		if !s.End.After(from) || !s.Start.Before(to) {
+			continue
+		}
+
+		rangeStart := s.Start
+		if from.After(rangeStart) {
+			rangeStart = from
+		}
+		rangeEnd := s.End
+		if to.Before(rangeEnd) {
+			rangeEnd = to
+		}
+
+		result = append(result, jsonSegment{
+			Path:     s.Path,
+			Start:    s.Start,
+			End:      s.End,
+			Duration: s.End.Sub(s.Start).Seconds(),
+			Offset:   rangeStart.Sub(s.Start).Seconds(),
+			Length:   rangeEnd.Sub(rangeStart).Seconds(),
+			Size:     s.Size,
+		})
+	}
+	return result, nil
+}
+
+// parseTimeParam accepts unix timestamp, RFC3339 or local "YYYY-MM-DDTHH:MM:SS" time
+func parseTimeParam(s string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Can not parse time \"%s\": use unix timestamp, RFC3339 or YYYY-MM-DDTHH:MM:SS", s)
+}
+
+// parseTimeRange reads "from" and "to" request parameters
+func parseTimeRange(r *http.Request) (from, to time.Time, err error) {
+	fromStr := r.FormValue("from")
+	toStr := r.FormValue("to")
+	if fromStr == "" || toStr == "" {
+		return from, to, errors.New("Both \"from\" and \"to\" parameters must be specified")
+	}
+
+	if from, err = parseTimeParam(fromStr); err != nil {
+		return from, to, err
+	}
+	if to, err = parseTimeParam(toStr); err != nil {
+		return from, to, err
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("\"from\" must be earlier than \"to\"")
+	}
+	return from, to, nil
+}
+
+func cameraSegments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	segments, err := findSegments(leech.Config, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, jsonSegmentsReply{Camera: camName, From: from, To: to, Segments: segments})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindSegments(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	camDir := filepath.Join(storagePath, "segcam")
+	start := time.Date(2019, 10, 20, 14, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		segStart := start.Add(time.Duration(i) * 10 * time.Minute)
+		name := segStart.Format(segmentNameLayout) + ".mkv"
+		createTestSegment(t, filepath.Join(camDir, segStart.Format("2006-01-02"), name), 100, segStart.Add(10*time.Minute))
+	}
+
+	c := cameraConfig{Name: "segcam", StoragePath: storagePath, Container: "mkv", FilenameTemplate: defaultFilenameTemplate}
+	segments, err := findSegments(c, start.Add(3*time.Minute), start.Add(20*time.Minute))
+	require.Nil(t, err)
+	require.Len(t, segments, 2)
+
+	assert.Equal(t, filepath.Join(camDir, "2019-10-20", "2019-10-20_14-00-00.mkv"), segments[0].Path)
+	assert.Equal(t, 600.0, segments[0].Duration)
+	assert.Equal(t, 180.0, segments[0].Offset)
+	assert.Equal(t, 420.0, segments[0].Length)
+	assert.Equal(t, 0.0, segments[1].Offset)
+	assert.Equal(t, 600.0, segments[1].Length)
+
+	segments, err = findSegments(c, start.Add(-time.Hour), start.Add(-time.Minute))
+	require.Nil(t, err)
+	assert.Len(t, segments, 0)
+}
+
+func TestParseTimeParam(t *testing.T) {
+	expected := time.Date(2019, 10, 20, 14, 3, 0, 0, time.Local)
+	for _, s := range []string{"2019-10-20T14:03:00", "2019-10-20 14:03:00", expected.Format(time.RFC3339), "1571580180"} {
+		parsed, err := parseTimeParam(s)
+		require.Nil(t, err, s)
+		if s == "1571580180" {
+			assert.Equal(t, int64(1571580180), parsed.Unix())
+			continue
+		}
+		assert.True(t, expected.Equal(parsed), s)
+	}
+
+	_, err := parseTimeParam("yesterday")
+	assert.NotNil(t, err)
+}
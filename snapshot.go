@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshots extracted from the segments are cached for that long, the extraction is killed after snapshotTimeout
+const (
+	snapshotCacheTTL = 10 * time.Second
+	snapshotTimeout  = 10 * time.Second
+)
+
+var (
+	snapshotCacheMu sync.Mutex
+	snapshotCache   = make(map[string]*snapshotCacheEntry)
+)
+
+type snapshotCacheEntry struct {
+	mu      sync.Mutex // held while the snapshot is being extracted, so concurrent requests wait for it
+	data    []byte
+	takenAt time.Time
+}
+
+// snapshotPath returns path of the JPEG file continuously updated by ffmpeg when snapshotInterval is set
+func snapshotPath(c cameraConfig) string {
+	return filepath.Join(cameraDir(c), "snapshot.jpg")
+}
+
+// snapshotInputArgs returns the input options of the recorder making the snapshot output decode only key frames.
+// They are not a part of ffmpegInputArgs, so that the probe measures the stream as is.
+func snapshotInputArgs() []string {
+	return []string{"-skip_frame", "nokey"}
+}
+
+// snapshotOutputArgs returns the additional ffmpeg output writing a JPEG every snapshotInterval seconds.
+// Only key frames are decoded (see snapshotInputArgs), so it is cheap on CPU.
+func snapshotOutputArgs(c cameraConfig) []string {
+	return []string{"-map", "0:v:0", "-r", fmt.Sprintf("1/%d", c.SnapshotInterval),
+		"-f", "image2", "-update", "1", "-q:v", "5", "-y", snapshotPath(c)}
+}
+
+// snapshotFromSegment extracts the latest frame written to the newest segment
+func snapshotFromSegment(c cameraConfig) ([]byte, error) {
+	segments, err := listCameraSegments(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, errNoFootage
+	}
+
+	newest := segments[len(segments)-1]
+	offset := newest.ModTime.Sub(newest.Start).Seconds() - 3
+	if offset < 0 {
+		offset = 0
+	}
+
+	ffmpegArgs := []string{"-hide_banner", "-nostdin", "-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", offset), "-i", newest.Path,
+		"-map", "0:v:0", "-frames:v", "1", "-f", "image2", "-c:v", "mjpeg", "-q:v", "5", "pipe:1"}
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, c.FfmpegPath, ffmpegArgs...).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("ffmpeg timed out after %v", snapshotTimeout)
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, exitErr.Stderr)
+		}
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("ffmpeg didn't produce a frame")
+	}
+	return out, nil
+}
+
+// cachedSnapshot returns snapshot from the cache or extracts a new one if the cached one is outdated
+func cachedSnapshot(c cameraConfig) ([]byte, time.Time, error) {
+	snapshotCacheMu.Lock()
+	entry, ok := snapshotCache[c.Name]
+	if !ok {
+		entry = &snapshotCacheEntry{}
+		snapshotCache[c.Name] = entry
+	}
+	snapshotCacheMu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.data != nil && time.Since(entry.takenAt) < snapshotCacheTTL {
+		return entry.data, entry.takenAt, nil
+	}
+
+	data, err := snapshotFromSegment(c)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	entry.data = data
+	entry.takenAt = time.Now()
+	return entry.data, entry.takenAt, nil
+}
+
+func cameraSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// ffmpeg keeps the snapshot file up to date, so it is served as is
+	if leech.Config.SnapshotInterval > 0 {
+		if _, err := os.Stat(snapshotPath(leech.Config)); err != nil {
+			http.Error(w, "Snapshot is not available yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeFile(w, r, snapshotPath(leech.Config))
+		return
+	}
+
+	data, takenAt, err := cachedSnapshot(leech.Config)
+	if err != nil {
+		if err == errNoFootage {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		log.Errorf("Camera %s: failed to take snapshot: %v", camName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Last-Modified", takenAt.UTC().Format(http.TimeFormat))
+	w.Write(data)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotPath(t *testing.T) {
+	c := cameraConfig{Name: "cam1", StoragePath: "/storage", FilenameTemplate: defaultFilenameTemplate}
+	assert.Equal(t, filepath.FromSlash("/storage/cam1/snapshot.jpg"), snapshotPath(c))
+
+	c.FilenameTemplate = "{hostname}/{camera}/%Y/%m/%d/%H-%M-%S"
+	assert.Equal(t, filepath.Join("/storage", hostname, "cam1", "snapshot.jpg"), snapshotPath(c))
+
+	// The segment root is shared by the cameras, so the snapshot goes to the camera subfolder
+	c.FilenameTemplate = "%Y-%m-%d/{camera}_%H-%M-%S"
+	assert.Equal(t, filepath.FromSlash("/storage/cam1/snapshot.jpg"), snapshotPath(c))
+}
+
+func TestCameraSnapshot(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	now := time.Now()
+	segStart := now.Add(-time.Minute).Truncate(time.Second)
+	name := segStart.Format(segmentNameLayout) + ".mkv"
+	createTestSegment(t, filepath.Join(storagePath, "cam1", segStart.Format("2006-01-02"), name), 100, now)
+
+	c := cameraConfig{Name: "cam1", FfmpegPath: "tests/fake_snapshot.sh", StoragePath: storagePath,
+		Container: "mkv", FilenameTemplate: defaultFilenameTemplate}
+	c2 := c
+	c2.Name = "cam2"
+	c2.SnapshotInterval = 10
+	leeches = map[string]*leech{"cam1": newLeech(c), "cam2": newLeech(c2)}
+	defer func() { leeches = make(map[string]*leech) }()
+
+	router := newRouter()
+	// Extracted from the newest segment
+	code, body := testGet(t, router, "/camera/cam1/snapshot.jpg")
+	require.Equal(t, 200, code, body)
+	assert.Equal(t, "jpeg\n", body)
+
+	// Written by ffmpeg when snapshotInterval is set
+	code, _ = testGet(t, router, "/camera/cam2/snapshot.jpg")
+	assert.Equal(t, 503, code)
+	require.Nil(t, os.MkdirAll(cameraDir(c2), 0755))
+	require.Nil(t, ioutil.WriteFile(snapshotPath(c2), []byte("live jpeg"), 0644))
+	code, body = testGet(t, router, "/camera/cam2/snapshot.jpg")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "live jpeg", body)
+
+	code, _ = testGet(t, router, "/camera/cam3/snapshot.jpg")
+	assert.Equal(t, 404, code)
+}
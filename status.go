@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-cmd/cmd"
+	"github.com/gorilla/mux"
+)
+
+var programStartedAt = time.Now()
+
+const (
+	stateRunning    = "running"
+	stateRestarting = "restarting"
+	stateStopped    = "stopped"
+)
+
+type jsonCameraStatus struct {
+	Name           string            `json:"name"`
+	State          string            `json:"state"`
+	Paused         bool              `json:"paused"`
+	PID            int               `json:"pid"`
+	Uptime         int64             `json:"uptime"` // seconds since the last ffmpeg start
+	Restarts       uint64            `json:"restarts"`
+	StallRestarts  uint64            `json:"stallRestarts"`
+	LastExitCode   int               `json:"lastExitCode"`
+	LastExitTime   int64             `json:"lastExitTime"`
+	CrashLoop      bool              `json:"crashLoop"`
+	CurrentSegment string            `json:"currentSegment"`
+	Errors         map[string]uint64 `json:"errors"` // amount of ffmpeg stderr lines by category
+	Config         cameraConfig      `json:"config"`
+	Stats          progressMessage   `json:"stats"`
+}
+
+type jsonRecorderStatus struct {
+	Commit    string             `json:"commit"`
+	BuiltAt   string             `json:"builtAt"`
+	GoVersion string             `json:"goVersion"`
+	Uptime    int64              `json:"uptime"` // seconds since the program start
+	Cameras   []jsonCameraStatus `json:"cameras"`
+}
+
+// currentSegment returns the newest segment file of the camera, the one ffmpeg is most likely writing to
+func currentSegment(c cameraConfig) string {
+	segmentCatalogMu.Lock()
+	infos, ok := segmentCatalog[c.Name]
+	segmentCatalogMu.Unlock()
+
+	if ok && len(infos) > 0 {
+		return infos[len(infos)-1].Path
+	}
+
+	segments, err := listCameraSegments(c)
+	if err != nil || len(segments) == 0 {
+		return ""
+	}
+	return segments[len(segments)-1].Path
+}
+
+// cameraState returns state of the camera along with the status of its ffmpeg process
+func cameraState(l *leech) (string, cmd.Status) {
+	l.controlMu.Lock()
+	running := l.watcherStarted
+	command := l.command
+	l.controlMu.Unlock()
+
+	if !running || command == nil {
+		return stateStopped, cmd.Status{}
+	}
+	status := command.Status()
+	if status.StartTs > 0 && status.StopTs == 0 {
+		return stateRunning, status
+	}
+	return stateRestarting, status
+}
+
+func getCameraStatus(l *leech) jsonCameraStatus {
+	s := jsonCameraStatus{
+		Name:          l.Config.Name,
+		State:         stateStopped,
+		Paused:        isCameraPaused(l.Config.Name),
+		Restarts:      atomic.LoadUint64(&l.Restarts),
+		StallRestarts: atomic.LoadUint64(&l.StallRestarts),
+		Config:        l.Config,
+		Stats:         l.Stats,
+	}
+	s.Config.URL = maskCredentials(s.Config.URL)
+	if s.Config.Password != "" {
+		s.Config.Password = maskedPassword
+	}
+
+	var status cmd.Status
+	s.State, status = cameraState(l)
+	if s.State == stateRunning {
+		s.PID = status.PID
+		s.Uptime = int64(status.Runtime)
+	}
+
+	exitCode, exitTime, crashLoop := l.getRestartState()
+	s.LastExitCode = exitCode
+	if !exitTime.IsZero() {
+		s.LastExitTime = exitTime.Unix()
+	}
+	s.CrashLoop = crashLoop
+	s.CurrentSegment = currentSegment(l.Config)
+	s.Errors = l.stderrLog.errorCounts()
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(json))
+}
+
+func cameraStatusJSON(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, getCameraStatus(leech))
+}
+
+func recorderStatusJSON(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(leeches))
+	for k := range leeches {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	reply := jsonRecorderStatus{
+		Commit:    commit,
+		BuiltAt:   builtat,
+		GoVersion: runtime.Version(),
+		Uptime:    int64(time.Since(programStartedAt).Seconds()),
+		Cameras:   make([]jsonCameraStatus, 0, len(names)),
+	}
+	for _, name := range names {
+		reply.Cameras = append(reply.Cameras, getCameraStatus(leeches[name]))
+	}
+	writeJSON(w, reply)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default segment file name template, relative to the storage path and without extension
+const defaultFilenameTemplate = "{camera}/%Y-%m-%d/%Y-%m-%d_%H-%M-%S"
+
+// Suffix of the segment ffmpeg is still writing. It is removed once the segment is complete.
+const partialSuffix = ".partial"
+
+var hostname string
+
+func init() {
+	hostname, _ = os.Hostname()
+}
+
+type containerFormat struct {
+	Extension     string
+	SegmentFormat string
+	FormatOptions string
+}
+
+// Supported segment containers
+var containers = map[string]containerFormat{
+	"mkv":    {"mkv", "matroska", ""},
+	"mp4":    {"mp4", "mp4", "movflags=+faststart"},
+	"fmp4":   {"mp4", "mp4", "movflags=+frag_keyframe+empty_moov+default_base_moof"},
+	"mpegts": {"ts", "mpegts", ""},
+}
+
+// strftime specifiers supported in the filename template and their regular expressions
+var templateSpecifiers = map[byte]string{
+	'Y': `(?P<Y>\d{4})`,
+	'm': `(?P<m>\d{2})`,
+	'd': `(?P<d>\d{2})`,
+	'H': `(?P<H>\d{2})`,
+	'M': `(?P<M>\d{2})`,
+	'S': `(?P<S>\d{2})`,
+}
+
+// validateFilenameTemplate checks that the template is relative, uses only supported specifiers
+// and has all of the date and time parts, so that segment start time can be told from the file name
+func validateFilenameTemplate(template string) error {
+	if path.IsAbs(template) {
+		return errors.New("filenameTemplate must be relative to the storage path")
+	}
+	// Otherwise cameras sharing the storage path would write and delete each other's segments
+	if !strings.Contains(template, "{camera}") {
+		return errors.New("filenameTemplate must contain {camera}")
+	}
+	for _, part := range strings.Split(template, "/") {
+		if part == "" || part == "." || part == ".." {
+			return errors.New("filenameTemplate must not contain empty, \".\" or \"..\" path elements")
+		}
+	}
+
+	seen := make(map[byte]bool)
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			continue
+		}
+		if i+1 == len(template) {
+			return errors.New("filenameTemplate must not end with %")
+		}
+		i++
+		if template[i] == '%' {
+			continue
+		}
+		if _, ok := templateSpecifiers[template[i]]; !ok {
+			return fmt.Errorf("filenameTemplate: unsupported specifier %%%c, supported are %%Y %%m %%d %%H %%M %%S", template[i])
+		}
+		// ffmpeg doesn't create folders, they are created only for the current and the next day
+		if strings.ContainsRune("HMS", rune(template[i])) && strings.Contains(template[i:], "/") {
+			return fmt.Errorf("filenameTemplate: %%%c may be used only in the file name, folders must not change within a day", template[i])
+		}
+		seen[template[i]] = true
+	}
+
+	for _, s := range []byte("YmdHMS") {
+		if !seen[s] {
+			return fmt.Errorf("filenameTemplate must contain %%%c", s)
+		}
+	}
+	return nil
+}
+
+// expandPlaceholders substitutes {camera} and {hostname} in the template
+func expandPlaceholders(template string, c cameraConfig) string {
+	return strings.NewReplacer("{camera}", c.Name, "{hostname}", hostname).Replace(template)
+}
+
+// strftime formats the time according to the template specifiers the same way ffmpeg does
+func strftime(template string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' || i+1 == len(template) {
+			b.WriteByte(template[i])
+			continue
+		}
+		i++
+		switch template[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", t.Month())
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		default:
+			b.WriteByte(template[i])
+		}
+	}
+	return b.String()
+}
+
+// segmentExtension returns file extension of the camera segments
+func segmentExtension(c cameraConfig) string {
+	return containers[c.Container].Extension
+}
+
+// segmentOutputPath returns ffmpeg segment muxer output path with strftime specifiers.
+// The segments are written with partial suffix, see finalizeSegment.
+func segmentOutputPath(c cameraConfig) string {
+	return filepath.Join(c.StoragePath, filepath.FromSlash(expandPlaceholders(c.FilenameTemplate, c))) + "." + segmentExtension(c) + partialSuffix
+}
+
+// segmentDirTemplate returns directory part of the expanded template
+func segmentDirTemplate(c cameraConfig) string {
+	return path.Dir(expandPlaceholders(c.FilenameTemplate, c))
+}
+
+// segmentDir returns the folder segments started at the given time are written to
+func segmentDir(c cameraConfig, t time.Time) string {
+	return filepath.Join(c.StoragePath, filepath.FromSlash(strftime(segmentDirTemplate(c), t)))
+}
+
+// segmentRoot returns the deepest folder which doesn't depend on time. All camera segments are under it.
+func segmentRoot(c cameraConfig) string {
+	static := make([]string, 0, 4)
+	for _, part := range strings.Split(segmentDirTemplate(c), "/") {
+		if strings.Contains(part, "%") {
+			break
+		}
+		static = append(static, part)
+	}
+	return filepath.Join(c.StoragePath, filepath.FromSlash(strings.Join(static, "/")))
+}
+
+// cameraDir returns the folder for the camera files other than segments, such as snapshot.
+// It is the segment root if the root belongs to the camera only, otherwise the camera subfolder of the root.
+func cameraDir(c cameraConfig) string {
+	for _, part := range strings.Split(path.Dir(c.FilenameTemplate), "/") {
+		if strings.Contains(part, "%") {
+			break
+		}
+		if strings.Contains(part, "{camera}") {
+			return segmentRoot(c)
+		}
+	}
+	return filepath.Join(segmentRoot(c), c.Name)
+}
+
+// templateRegexp converts the expanded template to the regular expression matching the paths it produces
+func templateRegexp(template string) *regexp.Regexp {
+	return regexp.MustCompile("^" + templatePattern(template) + "$")
+}
+
+func templatePattern(template string) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] == '%' && i+1 < len(template) {
+			i++
+			if re, ok := templateSpecifiers[template[i]]; ok {
+				b.WriteString(re)
+				continue
+			}
+		}
+		b.WriteString(regexp.QuoteMeta(string(template[i])))
+	}
+	return b.String()
+}
+
+// segmentPathRegexp returns regular expression matching segment paths relative to the storage path,
+// both complete and partial ones
+func segmentPathRegexp(c cameraConfig) *regexp.Regexp {
+	pattern := templatePattern(expandPlaceholders(c.FilenameTemplate, c) + "." + segmentExtension(c))
+	return regexp.MustCompile("^" + pattern + "(?:" + regexp.QuoteMeta(partialSuffix) + ")?$")
+}
+
+// parseSegmentStart gets segment start time from its path
+func parseSegmentStart(c cameraConfig, segmentPath string) (time.Time, error) {
+	rel, err := filepath.Rel(c.StoragePath, segmentPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTemplateTime(segmentPathRegexp(c), filepath.ToSlash(rel))
+}
+
+func parseTemplateTime(re *regexp.Regexp, s string) (time.Time, error) {
+	match := re.FindStringSubmatch(s)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("\"%s\" doesn't match the filename template", s)
+	}
+
+	values := map[string]int{"Y": 0, "m": 1, "d": 1, "H": 0, "M": 0, "S": 0}
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		v, err := strconv.Atoi(match[i])
+		if err != nil {
+			return time.Time{}, err
+		}
+		values[name] = v
+	}
+	return time.Date(values["Y"], time.Month(values["m"]), values["d"], values["H"], values["M"], values["S"], 0, time.Local), nil
+}
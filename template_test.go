@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFilenameTemplate(t *testing.T) {
+	assert.Nil(t, validateFilenameTemplate(defaultFilenameTemplate))
+	assert.Nil(t, validateFilenameTemplate("{hostname}/{camera}/%Y/%m/%d/%H-%M-%S"))
+	assert.NotNil(t, validateFilenameTemplate("/{camera}/%Y-%m-%d_%H-%M-%S"))
+	assert.NotNil(t, validateFilenameTemplate("{camera}/../%Y-%m-%d_%H-%M-%S"))
+	assert.NotNil(t, validateFilenameTemplate("{camera}/%Y-%m-%d_%H-%M"))
+	assert.NotNil(t, validateFilenameTemplate("{camera}/%Y-%m-%d_%H-%M-%S_%s"))
+	assert.NotNil(t, validateFilenameTemplate("{hostname}/%Y-%m-%d/%H-%M-%S"))
+	// Hourly folders would be missing when ffmpeg switches to the next hour
+	assert.NotNil(t, validateFilenameTemplate("{camera}/%Y/%m/%d/%H/%M-%S"))
+	assert.NotNil(t, validateFilenameTemplate("{camera}/%Y-%m-%d_%H/%M-%S"))
+	assert.Nil(t, validateFilenameTemplate("{camera}/%Y/%m/%d/%%H/%H-%M-%S"))
+}
+
+func TestSharedStoragePath(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	// Both cameras write into the same folders
+	template := "%Y-%m-%d/{camera}_%H-%M-%S"
+	now := time.Date(2019, 10, 20, 12, 0, 0, 0, time.Local)
+	cameras := make([]cameraConfig, 0, 2)
+	for _, name := range []string{"cam1", "cam10"} {
+		c := cameraConfig{Name: name, StoragePath: storagePath, Container: "mkv", FilenameTemplate: template, MaxStorageBytes: 1}
+		for i := 3; i > 0; i-- {
+			segStart := now.Add(-time.Duration(i) * time.Hour)
+			createTestSegment(t, filepath.Join(segmentDir(c, segStart), strftime(path.Base(expandPlaceholders(template, c)), segStart)+".mkv"), 10, segStart)
+		}
+		cameras = append(cameras, c)
+	}
+
+	segments, err := listCameraSegments(cameras[0])
+	require.Nil(t, err)
+	require.Len(t, segments, 3)
+	for _, s := range segments {
+		assert.True(t, strings.HasPrefix(filepath.Base(s.Path), "cam1_"), s.Path)
+	}
+
+	// Retention of one camera doesn't touch the other one
+	require.Nil(t, pruneCamera(cameras[0], now))
+	segments, err = listCameraSegments(cameras[0])
+	require.Nil(t, err)
+	assert.Len(t, segments, 1)
+	segments, err = listCameraSegments(cameras[1])
+	require.Nil(t, err)
+	assert.Len(t, segments, 3)
+}
+
+func TestFilenameTemplate(t *testing.T) {
+	c := cameraConfig{
+		Name:             "cam1",
+		StoragePath:      "/storage",
+		Container:        "mpegts",
+		FilenameTemplate: "{camera}/%Y/%m/%d/%H-%M-%S",
+	}
+	segStart := time.Date(2019, 10, 20, 14, 5, 0, 0, time.Local)
+
+	assert.Equal(t, filepath.FromSlash("/storage/cam1/%Y/%m/%d/%H-%M-%S.ts.partial"), segmentOutputPath(c))
+	assert.Equal(t, filepath.FromSlash("/storage/cam1/2019/10/20"), segmentDir(c, segStart))
+	assert.Equal(t, filepath.FromSlash("/storage/cam1"), segmentRoot(c))
+
+	start, err := parseSegmentStart(c, filepath.FromSlash("/storage/cam1/2019/10/20/14-05-00.ts"))
+	require.Nil(t, err)
+	assert.True(t, segStart.Equal(start))
+
+	_, err = parseSegmentStart(c, filepath.FromSlash("/storage/cam1/2019/10/20/14-05-00.mkv"))
+	assert.NotNil(t, err)
+	_, err = parseSegmentStart(c, filepath.FromSlash("/storage/cam2/2019/10/20/14-05-00.ts"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,10 @@
+#!/bin/sh
+# Fake ffmpeg for the export tests: writes the concat list into the output file (the last argument)
+for arg; do :; done
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-i" ]; then
+		list=$2
+	fi
+	shift
+done
+cat "$list" > "$arg"
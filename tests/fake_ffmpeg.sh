@@ -0,0 +1,24 @@
+#!/bin/sh
+# Fake ffmpeg for the tests: reports progress to stdout until terminated.
+# The frame counter doesn't advance when the input URL contains "stalled"
+step=25
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-i" ]; then
+		case "$2" in
+			*stalled*) step=0 ;;
+		esac
+	fi
+	shift
+done
+frame=25
+while true; do
+	echo "frame=$frame"
+	echo "fps=25.0"
+	echo "bitrate=N/A"
+	echo "out_time_ms=$((frame * 40000))"
+	echo "dup_frames=0"
+	echo "drop_frames=0"
+	echo "progress=continue"
+	frame=$((frame + step))
+	sleep 1
+done
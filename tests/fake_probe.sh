@@ -0,0 +1,38 @@
+#!/bin/sh
+# Fake ffmpeg for the probe tests: prints stream info of the input, or an error depending on the URL
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-i" ]; then
+		url=$2
+	fi
+	if [ "$1" = "-skip_frame" ]; then
+		echo "the probe must decode the stream as is, got -skip_frame" >&2
+		exit 1
+	fi
+	shift
+done
+case "$url" in
+	*refused*)
+		echo "[tcp @ 0x55d] Connection to tcp://127.0.0.1:554?timeout=0 failed: Connection refused" >&2
+		echo "$url: Connection refused" >&2
+		exit 1
+		;;
+	*dns*)
+		echo "[tcp @ 0x55d] Failed to resolve hostname nosuchcamera.local: Name or service not known" >&2
+		echo "$url: Input/output error" >&2
+		exit 1
+		;;
+	*unauthorized*)
+		echo "[rtsp @ 0x55d] method DESCRIBE failed: 401 Unauthorized" >&2
+		echo "$url: Server returned 401 Unauthorized (authorization failed)" >&2
+		exit 1
+		;;
+	*hang*)
+		exec sleep 10
+		;;
+esac
+echo "Input #0, rtsp, from '$url':" >&2
+echo "  Duration: N/A, start: 0.080000, bitrate: N/A" >&2
+echo "    Stream #0:0: Video: h264 (Main), yuv420p(progressive), 1920x1080, 25 fps, 25 tbr, 90k tbn, 50 tbc" >&2
+echo "    Stream #0:1: Audio: pcm_alaw, 8000 Hz, 1 channels, s16, 64 kb/s" >&2
+echo "Output #0, null, to 'pipe:':" >&2
+echo "    Stream #0:0: Video: h264 (Main), yuv420p(progressive), 1920x1080, q=2-31, 25 fps, 25 tbr, 90k tbn, 90k tbc" >&2
@@ -0,0 +1,3 @@
+#!/bin/sh
+# Fake ffmpeg for the snapshot tests: writes a picture to stdout
+echo "jpeg"
@@ -0,0 +1,3 @@
+#!/bin/sh
+# Fake ffmpeg for the VOD tests: prints its arguments as the remuxed segment
+echo "$@"
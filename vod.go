@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// Gap between recorded segments bigger than that is marked as discontinuity in VOD playlist
+const vodMaxGap = 1 * time.Second
+
+// Recorded segments are split into HLS segments of that many seconds, so that players can start and seek quickly
+const vodWindow = 6.0
+
+// vodPlaylist builds HLS VOD playlist where each recorded segment is split into vodWindow long HLS segments.
+// The windows are cut and remuxed to MPEG-TS on request (see cameraVODSegment), timestamps are shifted
+// by the window position in the playlist so that the whole range has continuous timeline.
+// Segment URIs are relative to the root folder of camera segments (see segmentRoot).
+func vodPlaylist(root string, segments []jsonSegment, complete bool) string {
+	var targetDuration float64
+	for _, s := range segments {
+		targetDuration = math.Max(targetDuration, math.Min(s.Duration, vodWindow))
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(targetDuration)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	if complete {
+		b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	} else {
+		b.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	}
+
+	var position float64
+	for i, s := range segments {
+		if i > 0 && s.Start.Sub(segments[i-1].End) > vodMaxGap {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", s.Start.Format("2006-01-02T15:04:05.000Z07:00"))
+
+		rel, err := filepath.Rel(root, s.Path)
+		if err != nil {
+			rel = filepath.Base(s.Path)
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		for i := range parts {
+			parts[i] = url.PathEscape(parts[i])
+		}
+		uri := "vod/" + strings.Join(parts, "/") + ".ts"
+
+		for start := 0.0; start < s.Duration; start += vodWindow {
+			length := math.Min(vodWindow, s.Duration-start)
+			fmt.Fprintf(&b, "#EXTINF:%.3f,\n", length)
+			fmt.Fprintf(&b, "%s?start=%.3f&length=%.3f&offset=%.3f\n", uri, start, length, position+start)
+		}
+		position += s.Duration
+	}
+
+	if complete {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String()
+}
+
+// completeSegments leaves out the segment ffmpeg is still writing. It is renamed once complete,
+// so its URI would break, and EVENT playlist entries must not change between reloads.
+// It is added to the playlist on the first reload after it is complete.
+func completeSegments(segments []jsonSegment) []jsonSegment {
+	result := make([]jsonSegment, 0, len(segments))
+	for _, s := range segments {
+		if !strings.HasSuffix(s.Path, partialSuffix) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func cameraVODPlaylist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	segments, err := findSegments(leech.Config, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	segments = completeSegments(segments)
+	if len(segments) == 0 {
+		http.Error(w, errNoFootage.Error(), http.StatusNotFound)
+		return
+	}
+
+	// The footage may still grow if the range isn't over yet, so the player has to reload the playlist
+	complete := time.Now().After(to.Add(time.Duration(leech.Config.SegmentTime) * time.Second))
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, vodPlaylist(segmentRoot(leech.Config), segments, complete))
+}
+
+func cameraVODSegment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	camName := vars["name"]
+
+	leech, ok := leeches[camName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Didn't find camera \"%s\"", camName), http.StatusNotFound)
+		return
+	}
+
+	// Only paths matching the filename template are served, so the path can't escape the camera folder
+	path := filepath.Join(segmentRoot(leech.Config), filepath.FromSlash(vars["path"]))
+	if _, err := parseSegmentStart(leech.Config, path); err != nil {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+
+	// A window is at most vodWindow long, so a single request can't make ffmpeg remux the whole segment
+	params := map[string]float64{"start": 0, "length": vodWindow, "offset": 0}
+	for name := range params {
+		valueStr := r.FormValue(name)
+		if valueStr == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil || value < 0 {
+			http.Error(w, fmt.Sprintf("Bad %s: must be non-negative number of seconds", name), http.StatusBadRequest)
+			return
+		}
+		params[name] = value
+	}
+	if params["length"] == 0 || params["length"] > vodWindow {
+		http.Error(w, fmt.Sprintf("Bad length: must be up to %.0f seconds", vodWindow), http.StatusBadRequest)
+		return
+	}
+
+	ffmpegArgs := []string{"-hide_banner", "-nostdin", "-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", params["start"]), "-i", path, "-t", fmt.Sprintf("%.3f", params["length"]),
+		"-map", "0:v:0", "-codec", "copy", "-output_ts_offset", fmt.Sprintf("%.3f", params["offset"]),
+		"-f", "mpegts", "pipe:1"}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	out := &writtenTracker{w: w}
+	var stderr bytes.Buffer
+	command := exec.CommandContext(r.Context(), leech.Config.FfmpegPath, ffmpegArgs...)
+	command.Stdout = out
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil && r.Context().Err() == nil {
+		log.Errorf("Camera %s: failed to remux segment %s: %v: %s", camName, path, err, strings.TrimSpace(stderr.String()))
+		// The status can only be changed while nothing has been sent
+		if !out.written {
+			http.Error(w, "Failed to remux segment", http.StatusInternalServerError)
+		}
+	}
+}
+
+// writtenTracker remembers whether anything has been written through it
+type writtenTracker struct {
+	w       io.Writer
+	written bool
+}
+
+func (t *writtenTracker) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		t.written = true
+	}
+	return t.w.Write(p)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVODPlaylist(t *testing.T) {
+	start := time.Date(2019, 10, 20, 14, 0, 0, 0, time.UTC)
+	segments := []jsonSegment{
+		{Path: "/storage/cam1/2019-10-20/2019-10-20_14-00-00.mkv", Start: start, End: start.Add(10 * time.Second), Duration: 10},
+		{Path: "/storage/cam1/2019-10-20/2019-10-20_14-00-10.mkv", Start: start.Add(10 * time.Second), End: start.Add(14500 * time.Millisecond), Duration: 4.5},
+		{Path: "/storage/cam1/2019-10-20/2019-10-20_14-30-00.mkv", Start: start.Add(1800 * time.Second), End: start.Add(1806 * time.Second), Duration: 6},
+	}
+
+	assert.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-VERSION:3\n"+
+		"#EXT-X-TARGETDURATION:6\n"+
+		"#EXT-X-MEDIA-SEQUENCE:0\n"+
+		"#EXT-X-PLAYLIST-TYPE:VOD\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2019-10-20T14:00:00.000Z\n"+
+		"#EXTINF:6.000,\n"+
+		"vod/2019-10-20/2019-10-20_14-00-00.mkv.ts?start=0.000&length=6.000&offset=0.000\n"+
+		"#EXTINF:4.000,\n"+
+		"vod/2019-10-20/2019-10-20_14-00-00.mkv.ts?start=6.000&length=4.000&offset=6.000\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2019-10-20T14:00:10.000Z\n"+
+		"#EXTINF:4.500,\n"+
+		"vod/2019-10-20/2019-10-20_14-00-10.mkv.ts?start=0.000&length=4.500&offset=10.000\n"+
+		"#EXT-X-DISCONTINUITY\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2019-10-20T14:30:00.000Z\n"+
+		"#EXTINF:6.000,\n"+
+		"vod/2019-10-20/2019-10-20_14-30-00.mkv.ts?start=0.000&length=6.000&offset=14.500\n"+
+		"#EXT-X-ENDLIST\n", vodPlaylist("/storage/cam1", segments, true))
+}
+
+func TestCameraVODPlaylistPartial(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	segStart := time.Date(2019, 10, 20, 14, 0, 0, 0, time.Local)
+	createTestSegment(t, filepath.Join(storagePath, "cam1", "2019-10-20", "2019-10-20_14-00-00.mkv"), 100, segStart.Add(time.Minute))
+	createTestSegment(t, filepath.Join(storagePath, "cam1", "2019-10-20", "2019-10-20_14-01-00.mkv.partial"), 100, segStart.Add(90*time.Second))
+
+	c := cameraConfig{Name: "cam1", StoragePath: storagePath, SegmentTime: 60, Container: "mkv", FilenameTemplate: defaultFilenameTemplate}
+	segments, err := listCameraSegments(c)
+	require.Nil(t, err)
+	updateSegmentCatalog(c.Name, segments)
+	leeches = map[string]*leech{"cam1": newLeech(c)}
+	defer func() { leeches = make(map[string]*leech) }()
+
+	code, body := testGet(t, newRouter(), "/camera/cam1/vod.m3u8?from=2019-10-20T14:00:00&to=2019-10-20T14:10:00")
+	require.Equal(t, 200, code, body)
+	assert.Contains(t, body, "vod/2019-10-20/2019-10-20_14-00-00.mkv.ts?start=0.000")
+	assert.NotContains(t, body, partialSuffix)
+
+	code, _ = testGet(t, newRouter(), "/camera/cam1/vod.m3u8?from=2019-10-20T14:01:00&to=2019-10-20T14:10:00")
+	assert.Equal(t, 404, code)
+}
+
+func TestCameraVODSegment(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	segStart := time.Date(2019, 10, 20, 14, 0, 0, 0, time.Local)
+	createTestSegment(t, filepath.Join(storagePath, "cam1", "2019-10-20", "2019-10-20_14-00-00.mkv"), 100, segStart.Add(time.Minute))
+
+	c := cameraConfig{Name: "cam1", FfmpegPath: "tests/fake_vod.sh", StoragePath: storagePath,
+		Container: "mkv", FilenameTemplate: defaultFilenameTemplate}
+	c2 := c
+	c2.FfmpegPath = "/bin/false"
+	leeches = map[string]*leech{"cam1": newLeech(c), "cam2": newLeech(c2)}
+	defer func() { leeches = make(map[string]*leech) }()
+
+	router := newRouter()
+	code, body := testGet(t, router, "/camera/cam1/vod/2019-10-20/2019-10-20_14-00-00.mkv.ts?start=12&length=6&offset=42")
+	require.Equal(t, 200, code, body)
+	assert.Contains(t, body, "-ss 12.000 -i "+filepath.Join(storagePath, "cam1", "2019-10-20", "2019-10-20_14-00-00.mkv")+" -t 6.000")
+	assert.Contains(t, body, "-output_ts_offset 42.000")
+
+	code, _ = testGet(t, router, "/camera/cam1/vod/2019-10-20/2019-10-20_14-00-00.mkv.ts?start=0&length=3600")
+	assert.Equal(t, 400, code)
+	code, _ = testGet(t, router, "/camera/cam1/vod/2019-10-20/2019-10-20_14-10-00.mkv.ts")
+	assert.Equal(t, 404, code)
+
+	// ffmpeg failed before writing anything
+	createTestSegment(t, filepath.Join(storagePath, "cam2", "2019-10-20", "2019-10-20_14-00-00.mkv"), 100, segStart.Add(time.Minute))
+	code, _ = testGet(t, router, "/camera/cam2/vod/2019-10-20/2019-10-20_14-00-00.mkv.ts")
+	assert.Equal(t, 500, code)
+}
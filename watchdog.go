@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-cmd/cmd"
+	log "github.com/sirupsen/logrus"
+)
+
+// How often the output grabber checks if the stream is stalled. It is a variable so that the tests can shorten it
+var stallCheckInterval = 5 * time.Second
+
+// resetProgress is called on ffmpeg start, so that the stall timeout counts from that moment
+func (l *leech) resetProgress() {
+	l.progressMu.Lock()
+	defer l.progressMu.Unlock()
+	l.lastProgressAt = time.Now()
+	l.lastProgressFrame = 0
+	l.lastProgressOutTime = 0
+}
+
+// trackProgress remembers the moment when frame counter or out time has advanced last time
+func (l *leech) trackProgress(msg progressMessage) {
+	l.progressMu.Lock()
+	advanced := msg.Frame > l.lastProgressFrame || msg.OutTime > l.lastProgressOutTime
+	if advanced {
+		l.lastProgressAt = time.Now()
+	}
+	if msg.Frame > l.lastProgressFrame {
+		l.lastProgressFrame = msg.Frame
+	}
+	if msg.OutTime > l.lastProgressOutTime {
+		l.lastProgressOutTime = msg.OutTime
+	}
+	l.progressMu.Unlock()
+
+	if !advanced {
+		return
+	}
+	l.stateMu.Lock()
+	recovered := l.recovering
+	l.recovering = false
+	l.stateMu.Unlock()
+	if recovered {
+		log.Infof("Camera %s: stream has recovered", l.Config.Name)
+		l.notify(eventRecovered)
+	}
+}
+
+// checkStall stops ffmpeg if the stream hasn't advanced for stallTimeout seconds.
+// The watcher then restarts it as usual.
+func (l *leech) checkStall(command *cmd.Cmd) {
+	if l.Config.StallTimeout <= 0 {
+		return
+	}
+
+	l.progressMu.Lock()
+	stalledFor := time.Since(l.lastProgressAt)
+	frame := l.lastProgressFrame
+	stalled := stalledFor >= time.Duration(l.Config.StallTimeout)*time.Second
+	if stalled {
+		// Don't try to stop it again until the next stall timeout passes
+		l.lastProgressAt = time.Now()
+	}
+	l.progressMu.Unlock()
+	if !stalled {
+		return
+	}
+
+	log.Warnf("Camera %s: stream is stalled for %v (frame %d), restarting ffmpeg", l.Config.Name, stalledFor.Truncate(time.Second), frame)
+	atomic.AddUint64(&l.StallRestarts, 1)
+
+	l.stateMu.Lock()
+	l.stallKill = true
+	l.stateMu.Unlock()
+
+	l.notify(eventStalled)
+
+	if err := command.Stop(); err != nil {
+		log.Errorf("Camera %s: error stopping stalled ffmpeg: %v", l.Config.Name, err)
+	}
+}
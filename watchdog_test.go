@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStallRestart(t *testing.T) {
+	storagePath, err := ioutil.TempDir("", "cameraleech")
+	require.Nil(t, err)
+	defer deleteDownloadedData(t, storagePath)
+
+	realInterval := stallCheckInterval
+	stallCheckInterval = 100 * time.Millisecond
+	defer func() { stallCheckInterval = realInterval }()
+
+	c := cameraConfig{
+		Name:              "cam1",
+		FfmpegPath:        "tests/fake_ffmpeg.sh",
+		FfmpegLogLevel:    "error",
+		StoragePath:       storagePath,
+		SegmentTime:       600,
+		URL:               "rtsp://127.0.0.1/stalled",
+		Container:         "mkv",
+		FilenameTemplate:  defaultFilenameTemplate,
+		RestartDelayMax:   1,
+		CrashLoopRestarts: 5,
+		CrashLoopWindow:   300,
+		StallTimeout:      1,
+	}
+	l := newLeech(c)
+	require.Nil(t, l.Start())
+	defer l.Stop()
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadUint64(&l.StallRestarts) > 0 && atomic.LoadUint64(&l.Restarts) > 0
+	}, 10*time.Second, 100*time.Millisecond)
+	assert.True(t, l.isRunning())
+
+	// Stall restarts don't count as crashes
+	_, _, crashLoop := l.getRestartState()
+	assert.False(t, crashLoop)
+}
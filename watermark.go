@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How often free space of the storage paths is checked
+const watermarkCheckInterval = 1 * time.Minute
+
+var (
+	storageStatsMu sync.Mutex
+	storageStats   = make(map[string]*storageStat)
+)
+
+type storageStat struct {
+	LastEviction time.Time
+	EvictedFiles uint64
+	EvictedBytes uint64
+}
+
+// diskUsage returns free (available to unprivileged users) and total bytes of the filesystem containing path.
+// It is a variable so that the tests can fake free space
+var diskUsage = func(path string) (free, total uint64, err error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, err
+	}
+	return fs.Bavail * uint64(fs.Bsize), fs.Blocks * uint64(fs.Bsize), nil
+}
+
+// validateWatermarks checks the free space watermarks, 0 low watermark disables the eviction.
+// 100% free space can never be reached, so such high watermark would evict all the footage.
+func validateWatermarks(low, high int) error {
+	if low == 0 {
+		if high != 0 {
+			return errors.New("freeSpaceHighWatermark requires freeSpaceLowWatermark")
+		}
+		return nil
+	}
+	if low < 0 || low > high || high >= 100 {
+		return errors.New("Free space watermarks must satisfy 0 <= freeSpaceLowWatermark <= freeSpaceHighWatermark < 100")
+	}
+	return nil
+}
+
+// watermarkWatcher periodically checks free space under every storage path
+// and evicts the oldest segments when it drops below the low watermark
+func watermarkWatcher() {
+	for {
+		if programIsStopping {
+			return
+		}
+
+		configMu.Lock()
+		low := config.FreeSpaceLowWatermark
+		high := config.FreeSpaceHighWatermark
+		storagePaths := make(map[string][]cameraConfig)
+		for _, c := range config.Cameras {
+			storagePaths[c.StoragePath] = append(storagePaths[c.StoragePath], c)
+		}
+		configMu.Unlock()
+
+		if low > 0 {
+			for path, cameras := range storagePaths {
+				if err := checkWatermark(path, cameras, low, high); err != nil {
+					log.Errorf("Storage %s: error checking free space: %v", path, err)
+				}
+			}
+		}
+		time.Sleep(watermarkCheckInterval)
+	}
+}
+
+func checkWatermark(storagePath string, cameras []cameraConfig, low, high int) error {
+	free, total, err := diskUsage(storagePath)
+	if err != nil {
+		return err
+	}
+	if total == 0 || free*100 >= total*uint64(low) {
+		return nil
+	}
+
+	target := total * uint64(high) / 100
+	log.Warnf("Storage %s: free space %d bytes is below the low watermark (%d%%), evicting the oldest segments", storagePath, free, low)
+
+	// Gather segments of every camera using the storage path, keeping the newest segment of each camera
+	segments := make([]segmentFile, 0, 1024)
+	segmentCamera := make(map[string]cameraConfig)
+	for _, c := range cameras {
+		camSegments, err := listCameraSegments(c)
+		if err != nil {
+			log.Errorf("Camera %s: failed to list segments: %v", c.Name, err)
+			continue
+		}
+		if len(camSegments) == 0 {
+			continue
+		}
+		for _, s := range camSegments[:len(camSegments)-1] {
+			if s.Partial {
+				continue
+			}
+			segmentCamera[s.Path] = c
+			segments = append(segments, s)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].ModTime.Before(segments[j].ModTime)
+	})
+
+	var evictedFiles, evictedBytes uint64
+	touchedCameras := make(map[string]cameraConfig)
+	for _, s := range segments {
+		if free >= target {
+			break
+		}
+		c := segmentCamera[s.Path]
+		if err := os.Remove(s.Path); err != nil {
+			log.Errorf("Camera %s: failed to remove segment %s: %v", c.Name, s.Path, err)
+			continue
+		}
+		log.Infof("Camera %s: low free space, evicted segment %s (%d bytes)", c.Name, s.Path, s.Size)
+		free += uint64(s.Size)
+		evictedFiles++
+		evictedBytes += uint64(s.Size)
+		touchedCameras[c.Name] = c
+	}
+
+	for _, c := range touchedCameras {
+		if err := removeEmptySegmentFolders(c, time.Now()); err != nil {
+			log.Errorf("Camera %s: failed to remove empty folders: %v", c.Name, err)
+		}
+	}
+
+	addStorageEviction(storagePath, evictedFiles, evictedBytes)
+
+	if free < target {
+		log.Errorf("Storage %s: could not reach the high watermark (%d%%), nothing left to evict", storagePath, high)
+	}
+	return nil
+}
+
+func addStorageEviction(storagePath string, evictedFiles, evictedBytes uint64) {
+	if evictedFiles == 0 {
+		return
+	}
+
+	storageStatsMu.Lock()
+	defer storageStatsMu.Unlock()
+
+	stat, ok := storageStats[storagePath]
+	if !ok {
+		stat = &storageStat{}
+		storageStats[storagePath] = stat
+	}
+	stat.LastEviction = time.Now()
+	stat.EvictedFiles += evictedFiles
+	stat.EvictedBytes += evictedBytes
+}
+
+func getStorageStat(storagePath string) storageStat {
+	storageStatsMu.Lock()
+	defer storageStatsMu.Unlock()
+
+	stat, ok := storageStats[storagePath]
+	if !ok {
+		return storageStat{}
+	}
+	return *stat
+}
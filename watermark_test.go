@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckWatermark(t *testing.T) {
+	now := time.Date(2019, 10, 20, 12, 0, 0, 0, time.Local)
+	segments := []struct {
+		camera string
+		name   string
+		age    time.Duration
+	}{
+		{"cam1", "2019-10-20_08-00-00", 4 * time.Hour},
+		{"cam2", "2019-10-20_09-00-00", 3 * time.Hour},
+		{"cam1", "2019-10-20_10-00-00", 2 * time.Hour},
+		{"cam2", "2019-10-20_11-00-00", time.Hour},
+		{"cam1", "2019-10-20_11-00-00", time.Hour},
+	}
+
+	tests := []struct {
+		name      string
+		free      uint64
+		low, high int
+		remaining []string // segments left after the check
+	}{
+		{"above low watermark", 60, 50, 70, []string{"cam1/2019-10-20_08-00-00", "cam2/2019-10-20_09-00-00",
+			"cam1/2019-10-20_10-00-00", "cam2/2019-10-20_11-00-00", "cam1/2019-10-20_11-00-00"}},
+		{"oldest segments across cameras", 40, 50, 60, []string{"cam1/2019-10-20_10-00-00",
+			"cam2/2019-10-20_11-00-00", "cam1/2019-10-20_11-00-00"}},
+		{"newest segment of each camera is kept", 0, 50, 99, []string{"cam2/2019-10-20_11-00-00",
+			"cam1/2019-10-20_11-00-00"}},
+	}
+
+	realDiskUsage := diskUsage
+	defer func() { diskUsage = realDiskUsage }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storagePath, err := ioutil.TempDir("", "cameraleech")
+			require.Nil(t, err)
+			defer deleteDownloadedData(t, storagePath)
+
+			// Every segment is 10 bytes of 100 bytes filesystem
+			for _, s := range segments {
+				path := filepath.Join(storagePath, s.camera, "2019-10-20", s.name+".mkv")
+				createTestSegment(t, path, 10, now.Add(-s.age))
+			}
+			diskUsage = func(path string) (uint64, uint64, error) {
+				return tt.free, 100, nil
+			}
+
+			cameras := []cameraConfig{
+				{Name: "cam1", StoragePath: storagePath, Container: "mkv", FilenameTemplate: defaultFilenameTemplate},
+				{Name: "cam2", StoragePath: storagePath, Container: "mkv", FilenameTemplate: defaultFilenameTemplate},
+			}
+			require.Nil(t, checkWatermark(storagePath, cameras, tt.low, tt.high))
+
+			remaining := make([]string, 0)
+			for _, s := range segments {
+				path := filepath.Join(storagePath, s.camera, "2019-10-20", s.name+".mkv")
+				if _, err := os.Stat(path); err == nil {
+					remaining = append(remaining, s.camera+"/"+s.name)
+				}
+			}
+			assert.Equal(t, tt.remaining, remaining)
+
+			// Last eviction time is set only if something was evicted
+			stat := getStorageStat(storagePath)
+			assert.Equal(t, len(remaining) < len(segments), !stat.LastEviction.IsZero())
+			assert.Equal(t, uint64(len(segments)-len(remaining)), stat.EvictedFiles)
+		})
+	}
+}
+
+func TestValidateWatermarks(t *testing.T) {
+	tests := []struct {
+		low, high int
+		valid     bool
+	}{
+		{0, 0, true},
+		{5, 10, true},
+		{10, 10, true},
+		{90, 99, true},
+		{0, 10, false},
+		{-5, 10, false},
+		{10, 5, false},
+		{50, 100, false},
+		{100, 100, false},
+	}
+	for _, tt := range tests {
+		err := validateWatermarks(tt.low, tt.high)
+		assert.Equal(t, tt.valid, err == nil, "low %d, high %d", tt.low, tt.high)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Network timeout of a single Zabbix sender request
+const zabbixTimeout = 10 * time.Second
+
+// Zabbix protocol header: "ZBXD" signature and protocol version 1
+var zabbixHeader = []byte("ZBXD\x01")
+
+type zabbixConfig struct {
+	Server    string // Zabbix server or proxy host:port, sender is disabled when empty
+	Host      string // host name in Zabbix the items belong to
+	Interval  int    // seconds between sending the items
+	BatchSize int    // max amount of values sent in a single request
+}
+
+type zabbixItem struct {
+	key   string
+	value func(l *leech) string // empty value isn't sent
+}
+
+// Camera items are sent as <key>[<camera name>], the same keys as in userparameter_cameraleech.conf
+var zabbixItems = []zabbixItem{
+	{"camera.frame", func(l *leech) string { return fmt.Sprintf("%d", l.Stats.Frame) }},
+	{"camera.fps", func(l *leech) string { return fmt.Sprintf("%f", l.Stats.Fps) }},
+	{"camera.bitrate", func(l *leech) string { return fmt.Sprintf("%d", l.Stats.Bitrate) }},
+	{"camera.outtime", func(l *leech) string { return fmt.Sprintf("%d", l.Stats.OutTime/1000000) }},
+	{"camera.dupframes", func(l *leech) string { return fmt.Sprintf("%d", l.Stats.DupFrames) }},
+	{"camera.dropframes", func(l *leech) string { return fmt.Sprintf("%d", l.Stats.DropFrames) }},
+	{"camera.restarts", func(l *leech) string { return fmt.Sprintf("%d", atomic.LoadUint64(&l.Restarts)) }},
+	{"camera.stallrestarts", func(l *leech) string { return fmt.Sprintf("%d", atomic.LoadUint64(&l.StallRestarts)) }},
+	{"camera.lastexitcode", func(l *leech) string { code, _, _ := l.getRestartState(); return fmt.Sprintf("%d", code) }},
+	{"camera.lastexittime", func(l *leech) string {
+		if _, exitTime, _ := l.getRestartState(); !exitTime.IsZero() {
+			return fmt.Sprintf("%d", exitTime.Unix())
+		}
+		return "0"
+	}},
+	{"camera.crashloop", func(l *leech) string {
+		if _, _, crashLoop := l.getRestartState(); crashLoop {
+			return "1"
+		}
+		return "0"
+	}},
+	{"camera.retentionfreed", func(l *leech) string { return fmt.Sprintf("%d", getRetentionStat(l.Config.Name).FreedBytes) }},
+	{"camera.hookfailures", func(l *leech) string { return fmt.Sprintf("%d", getHookStat(l.Config.Name).Failures) }},
+	{"camera.freespace", func(l *leech) string {
+		free, _, err := diskUsage(l.Config.StoragePath)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%d", free)
+	}},
+	{"camera.lasteviction", func(l *leech) string {
+		if stat := getStorageStat(l.Config.StoragePath); !stat.LastEviction.IsZero() {
+			return fmt.Sprintf("%d", stat.LastEviction.Unix())
+		}
+		return "0"
+	}},
+}
+
+type zabbixValue struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+type zabbixSenderRequest struct {
+	Request string        `json:"request"`
+	Data    []zabbixValue `json:"data"`
+	Clock   int64         `json:"clock"`
+}
+
+type zabbixSenderResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+// zabbixSenderWatcher periodically pushes the items of all the cameras to Zabbix
+func zabbixSenderWatcher() {
+	for {
+		if programIsStopping {
+			return
+		}
+
+		configMu.Lock()
+		c := config.Zabbix
+		configMu.Unlock()
+
+		if c.Server != "" {
+			if err := sendZabbixValues(c, collectZabbixValues(c.Host, time.Now())); err != nil {
+				log.Errorf("Failed to send items to Zabbix %s: %v", c.Server, err)
+			}
+		}
+		time.Sleep(time.Duration(c.Interval) * time.Second)
+	}
+}
+
+// collectZabbixValues returns discovery data followed by the items of every camera
+func collectZabbixValues(host string, now time.Time) []zabbixValue {
+	names := make([]string, 0, len(leeches))
+	for k := range leeches {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	values := make([]zabbixValue, 0, 1+len(names)*len(zabbixItems))
+	if discovery, err := camerasListJSON(); err == nil {
+		values = append(values, zabbixValue{Host: host, Key: "camera.discovery", Value: string(discovery), Clock: now.Unix()})
+	}
+	for _, name := range names {
+		l := leeches[name]
+		for _, item := range zabbixItems {
+			value := item.value(l)
+			if value == "" {
+				continue
+			}
+			values = append(values, zabbixValue{Host: host, Key: fmt.Sprintf("%s[%s]", item.key, name), Value: value, Clock: now.Unix()})
+		}
+		for category, count := range l.stderrLog.errorCounts() {
+			values = append(values, zabbixValue{Host: host, Key: fmt.Sprintf("camera.errors[%s,%s]", name, category), Value: fmt.Sprint(count), Clock: now.Unix()})
+		}
+	}
+	return values
+}
+
+// sendZabbixValues sends the values in batches of batchSize
+func sendZabbixValues(c zabbixConfig, values []zabbixValue) error {
+	for len(values) > 0 {
+		n := c.BatchSize
+		if n > len(values) {
+			n = len(values)
+		}
+		resp, err := zabbixSend(c.Server, values[:n])
+		if err != nil {
+			return err
+		}
+		// Values of the items which aren't discovered yet are rejected, that's not an error
+		log.Debugf("Zabbix %s: %s", c.Server, resp.Info)
+		values = values[n:]
+	}
+	return nil
+}
+
+// zabbixSend makes a single Zabbix sender (trapper) protocol request
+func zabbixSend(server string, values []zabbixValue) (zabbixSenderResponse, error) {
+	var resp zabbixSenderResponse
+
+	data, err := json.Marshal(zabbixSenderRequest{Request: "sender data", Data: values, Clock: time.Now().Unix()})
+	if err != nil {
+		return resp, err
+	}
+
+	conn, err := net.DialTimeout("tcp", server, zabbixTimeout)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(zabbixTimeout))
+
+	if _, err := conn.Write(zabbixPacket(data)); err != nil {
+		return resp, err
+	}
+
+	reply, err := readZabbixPacket(conn)
+	if err != nil {
+		return resp, err
+	}
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		return resp, err
+	}
+	if resp.Response != "success" {
+		return resp, fmt.Errorf("server responded \"%s\": %s", resp.Response, resp.Info)
+	}
+	return resp, nil
+}
+
+// zabbixPacket prepends the data with the protocol header and data length
+func zabbixPacket(data []byte) []byte {
+	var b bytes.Buffer
+	b.Write(zabbixHeader)
+	binary.Write(&b, binary.LittleEndian, uint64(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func readZabbixPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(zabbixHeader)], zabbixHeader) {
+		return nil, errors.New("bad Zabbix protocol header")
+	}
+	length := binary.LittleEndian.Uint64(header[len(zabbixHeader):])
+	return ioutil.ReadAll(io.LimitReader(r, int64(length)))
+}
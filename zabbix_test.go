@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZabbixSender(t *testing.T) {
+	l := newLeech(cameraConfig{Name: "cam1", StoragePath: "/"})
+	l.Stats = progressMessage{Frame: 42, OutTime: 1500000}
+	leeches = map[string]*leech{"cam1": l}
+	defer func() { leeches = nil }()
+
+	values := collectZabbixValues("recorder", time.Unix(1571580000, 0))
+	require.Len(t, values, 1+len(zabbixItems)+len(logCategories)+1)
+	assert.Equal(t, "camera.discovery", values[0].Key)
+	assert.Contains(t, values[0].Value, "\"{#CAMERA}\": \"cam1\"")
+	assert.Equal(t, zabbixValue{Host: "recorder", Key: "camera.frame[cam1]", Value: "42", Clock: 1571580000}, values[1])
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+
+	requests := make(chan zabbixSenderRequest, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			data, err := readZabbixPacket(conn)
+			if err == nil {
+				var req zabbixSenderRequest
+				json.Unmarshal(data, &req)
+				requests <- req
+				conn.Write(zabbixPacket([]byte(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000055"}`)))
+			}
+			conn.Close()
+		}
+	}()
+
+	c := zabbixConfig{Server: ln.Addr().String(), Host: "recorder", BatchSize: 10}
+	err = sendZabbixValues(c, values)
+	require.Nil(t, err)
+
+	// Values are split into batches of 10
+	total := 0
+	for i := 0; i < (len(values)+9)/10; i++ {
+		req := <-requests
+		assert.Equal(t, "sender data", req.Request)
+		total += len(req.Data)
+	}
+	assert.Equal(t, len(values), total)
+}